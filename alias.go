@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// aliasPattern extracts fields such as env, service or region from a host
+// alias through its named groups. Without one the alias is split on "_" and
+// the last segment is the env.
+var aliasPattern *regexp.Regexp
+
+var errNoAliasFields = errors.New("alias pattern has no named groups")
+
+func compileAliasPattern(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			return re, nil
+		}
+	}
+	return nil, errNoAliasFields
+}
+
+// aliasFields returns the named groups of aliasPattern that matched alias.
+func aliasFields(alias string) map[string]string {
+	if aliasPattern == nil {
+		return nil
+	}
+	match := aliasPattern.FindStringSubmatch(alias)
+	if match == nil {
+		return nil
+	}
+	fields := make(map[string]string)
+	for i, name := range aliasPattern.SubexpNames() {
+		if name != "" && match[i] != "" {
+			fields[name] = match[i]
+		}
+	}
+	return fields
+}
+
+// isFieldName tells whether a tag named name overrides a field of the alias.
+func isFieldName(name string) bool {
+	if name == "env" {
+		return true
+	}
+	return aliasPattern != nil && aliasPattern.SubexpIndex(name) > 0
+}
+
+// Columns is the fields of the alias other than env, in pattern order, for
+// the host list.
+func (h *Host) Columns() string {
+	if aliasPattern == nil {
+		return ""
+	}
+	columns := make([]string, 0)
+	for _, name := range aliasPattern.SubexpNames() {
+		if name != "" && name != "env" && h.Fields[name] != "" {
+			columns = append(columns, h.Fields[name])
+		}
+	}
+	return strings.Join(columns, " ")
+}
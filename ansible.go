@@ -0,0 +1,690 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gogf/gf/encoding/gyaml"
+	"gopkg.in/yaml.v3"
+)
+
+const maxAnsibleDepth = 16
+
+// ansibleInventory is an Ansible inventory as groups of host names, before it
+// is turned into jump's inventory. A host may belong to several groups.
+type ansibleInventory struct {
+	groups   map[string]*ansibleGroup
+	order    []string
+	hostVars map[string]map[string]string
+}
+
+type ansibleGroup struct {
+	name     string
+	hosts    []string
+	children []string
+	vars     map[string]string
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{
+		groups:   make(map[string]*ansibleGroup),
+		hostVars: make(map[string]map[string]string),
+	}
+}
+
+func (a *ansibleInventory) group(name string) *ansibleGroup {
+	if g, ok := a.groups[name]; ok {
+		return g
+	}
+	g := &ansibleGroup{name: name, vars: make(map[string]string)}
+	a.groups[name] = g
+	a.order = append(a.order, name)
+	return g
+}
+
+func (a *ansibleInventory) addHost(group, name string, vars map[string]string) {
+	g := a.group(group)
+	if !containsString(g.hosts, name) {
+		g.hosts = append(g.hosts, name)
+	}
+	if a.hostVars[name] == nil {
+		a.hostVars[name] = make(map[string]string)
+	}
+	for k, v := range vars {
+		a.hostVars[name][k] = v
+	}
+}
+
+func (a *ansibleInventory) addChild(parent, child string) {
+	g := a.group(parent)
+	a.group(child)
+	if !containsString(g.children, child) {
+		g.children = append(g.children, child)
+	}
+}
+
+// inventory nests the groups under the top-level ones, which become the Env
+// of their hosts. Hosts that are only in all or ungrouped stay at the top.
+func (a *ansibleInventory) inventory(path string) (*inventory, error) {
+	inv := &inventory{}
+	grouped := make(map[string]bool)
+	isChild := make(map[string]bool)
+	for _, name := range a.order {
+		g := a.groups[name]
+		if name != "all" {
+			for _, c := range g.children {
+				isChild[c] = true
+			}
+		}
+		if name != "all" && name != "ungrouped" {
+			for _, h := range g.hosts {
+				grouped[h] = true
+			}
+		}
+	}
+
+	if all, ok := a.groups["all"]; ok {
+		options, err := ansibleOptions(all.vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s: group all: %v", path, err)
+		}
+		inv.Defaults = options
+	}
+	for _, name := range a.order {
+		if name == "all" || name == "ungrouped" || isChild[name] {
+			continue
+		}
+		g, err := a.inventoryGroup(path, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		inv.Groups = append(inv.Groups, g)
+	}
+	for _, name := range []string{"all", "ungrouped"} {
+		g, ok := a.groups[name]
+		if !ok {
+			continue
+		}
+		for _, h := range g.hosts {
+			if grouped[h] {
+				continue
+			}
+			grouped[h] = true
+			host, err := a.inventoryHost(path, h)
+			if err != nil {
+				return nil, err
+			}
+			inv.Hosts = append(inv.Hosts, host)
+		}
+	}
+	return inv, nil
+}
+
+func (a *ansibleInventory) inventoryGroup(path, name string, depth int) (*inventoryGroup, error) {
+	if depth > maxAnsibleDepth {
+		return nil, fmt.Errorf("%s: group %s: children nested too deep", path, name)
+	}
+	g := a.groups[name]
+	options, err := ansibleOptions(g.vars)
+	if err != nil {
+		return nil, fmt.Errorf("%s: group %s: %v", path, name, err)
+	}
+	group := &inventoryGroup{Name: name, inventoryOptions: options}
+	for _, h := range g.hosts {
+		host, err := a.inventoryHost(path, h)
+		if err != nil {
+			return nil, err
+		}
+		group.Hosts = append(group.Hosts, host)
+	}
+	for _, c := range g.children {
+		child, err := a.inventoryGroup(path, c, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		group.Children = append(group.Children, child)
+	}
+	return group, nil
+}
+
+func (a *ansibleInventory) inventoryHost(path, name string) (*inventoryHost, error) {
+	vars := a.hostVars[name]
+	options, err := ansibleOptions(vars)
+	if err != nil {
+		return nil, fmt.Errorf("%s: host %s: %v", path, name, err)
+	}
+	return &inventoryHost{Name: name, HostName: vars["ansible_host"], inventoryOptions: options}, nil
+}
+
+// ansibleOptions maps the connection variables of Ansible's ssh plugin. Other
+// variables are not about connecting and are left out.
+func ansibleOptions(vars map[string]string) (inventoryOptions, error) {
+	o := inventoryOptions{}
+	o.User = firstNonEmpty(vars["ansible_user"], vars["ansible_ssh_user"])
+	if port := firstNonEmpty(vars["ansible_port"], vars["ansible_ssh_port"]); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return o, fmt.Errorf("invalid ansible_port %q", port)
+		}
+		o.Port = n
+	}
+	o.IdentityFile = firstNonEmpty(vars["ansible_ssh_private_key_file"], vars["ansible_private_key_file"])
+
+	args := strings.TrimSpace(vars["ansible_ssh_common_args"] + " " + vars["ansible_ssh_extra_args"])
+	if args == "" {
+		return o, nil
+	}
+	fields, err := shellFields(args)
+	if err != nil {
+		return o, fmt.Errorf("ansible_ssh_common_args: %v", err)
+	}
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		switch {
+		case f == "-J" && i+1 < len(fields):
+			i++
+			o.Bastion = fields[i]
+		case f == "-o" && i+1 < len(fields):
+			i++
+			o.setSSHOption(fields[i])
+		case strings.HasPrefix(f, "-o"):
+			o.setSSHOption(f[2:])
+		}
+	}
+	return o, nil
+}
+
+// setSSHOption records a -o Key=Value argument. A ProxyCommand that merely
+// runs ssh -W through another host is kept as that host's ProxyJump.
+func (o *inventoryOptions) setSSHOption(option string) {
+	i := strings.IndexAny(option, "= ")
+	if i < 0 {
+		return
+	}
+	key, value := option[:i], strings.TrimSpace(option[i+1:])
+	switch strings.ToLower(key) {
+	case "proxyjump":
+		o.Bastion = value
+		return
+	case "proxycommand":
+		if jump := proxyCommandJump(value); jump != "" {
+			o.Bastion = jump
+			return
+		}
+	}
+	if o.Options == nil {
+		o.Options = make(map[string]interface{})
+	}
+	o.Options[key] = value
+}
+
+// proxyCommandJump returns the [user@]host[:port] of "ssh [-q] [-p port]
+// [-l user] -W %h:%p host", or "" for any other command.
+func proxyCommandJump(command string) string {
+	fields, err := shellFields(command)
+	if err != nil || len(fields) < 3 || fields[0] != "ssh" {
+		return ""
+	}
+	host, user, port, forward := "", "", "", false
+	for i := 1; i < len(fields); i++ {
+		switch fields[i] {
+		case "-q":
+		case "-W":
+			if i+1 >= len(fields) || fields[i+1] != "%h:%p" {
+				return ""
+			}
+			i++
+			forward = true
+		case "-p", "-l":
+			if i+1 >= len(fields) {
+				return ""
+			}
+			if fields[i] == "-p" {
+				port = fields[i+1]
+			} else {
+				user = fields[i+1]
+			}
+			i++
+		default:
+			if strings.HasPrefix(fields[i], "-") || host != "" {
+				return ""
+			}
+			host = fields[i]
+		}
+	}
+	if !forward || host == "" {
+		return ""
+	}
+	if user != "" && !strings.Contains(host, "@") {
+		host = user + "@" + host
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	return host
+}
+
+// parseAnsibleINI reads an INI inventory: [group] sections of host lines with
+// key=value variables, [group:vars] and [group:children] sections.
+func parseAnsibleINI(path string, data []byte) (*inventory, error) {
+	a := newAnsibleInventory()
+	section, kind := "ungrouped", ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section, kind = line[1:len(line)-1], ""
+			if i := strings.LastIndex(section, ":"); i >= 0 {
+				section, kind = section[:i], section[i+1:]
+			}
+			if kind != "" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("%s:%d: unknown section type %q", path, lineNum, kind)
+			}
+			a.group(section)
+			continue
+		}
+
+		fields, err := shellFields(stripINIComment(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		if len(fields) == 0 && kind != "vars" {
+			continue
+		}
+		switch kind {
+		case "children":
+			a.addChild(section, fields[0])
+		case "vars":
+			i := strings.Index(line, "=")
+			if i < 0 {
+				return nil, fmt.Errorf("%s:%d: expected key=value", path, lineNum)
+			}
+			value := strings.TrimSpace(line[i+1:])
+			if unquoted, err := shellFields(value); err == nil && len(unquoted) == 1 {
+				value = unquoted[0]
+			}
+			a.group(section).vars[strings.TrimSpace(line[:i])] = value
+		default:
+			vars := make(map[string]string)
+			for _, f := range fields[1:] {
+				i := strings.Index(f, "=")
+				if i < 0 {
+					return nil, fmt.Errorf("%s:%d: expected key=value, got %q", path, lineNum, f)
+				}
+				vars[f[:i]] = f[i+1:]
+			}
+			pattern, port := splitHostPort(fields[0])
+			if _, ok := vars["ansible_port"]; !ok && port != "" {
+				vars["ansible_port"] = port
+			}
+			names, err := expandHostPattern(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+			}
+			for _, name := range names {
+				a.addHost(section, name, vars)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a.inventory(path)
+}
+
+// stripINIComment drops a # comment from a host or children line. Like the
+// shell, Ansible only starts one at an unquoted # that begins a word.
+func stripINIComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitHostPort splits the port off Ansible's host:port and [v6addr]:port
+// shorthands. A colon inside a range such as web[1:3] is not a port.
+func splitHostPort(pattern string) (string, string) {
+	i := strings.LastIndex(pattern, ":")
+	if i < 0 || strings.Count(pattern[:i], "[") != strings.Count(pattern[:i], "]") {
+		return pattern, ""
+	}
+	host, port := pattern[:i], pattern[i+1:]
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return pattern, ""
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") && strings.Contains(host, ":") {
+		return host[1 : len(host)-1], port
+	}
+	if strings.Contains(host, ":") && !strings.Contains(host, "[") {
+		// A bare IPv6 address.
+		return pattern, ""
+	}
+	return host, port
+}
+
+// parseAnsibleYAML reads a YAML inventory, keeping the order of the file.
+func parseAnsibleYAML(path string, data []byte) (*inventory, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	a := newAnsibleInventory()
+	if len(doc.Content) == 0 {
+		return a.inventory(path)
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: expected a mapping of groups", path)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if err := a.addYAMLGroup(root.Content[i].Value, root.Content[i+1], 0); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return a.inventory(path)
+}
+
+func (a *ansibleInventory) addYAMLGroup(name string, node *yaml.Node, depth int) error {
+	if depth > maxAnsibleDepth {
+		return fmt.Errorf("group %s: children nested too deep", name)
+	}
+	g := a.group(name)
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		if value.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			k, v := value.Content[j].Value, value.Content[j+1]
+			switch key {
+			case "hosts":
+				names, err := expandHostPattern(k)
+				if err != nil {
+					return fmt.Errorf("group %s: %v", name, err)
+				}
+				for _, h := range names {
+					a.addHost(name, h, yamlVars(v))
+				}
+			case "vars":
+				if v.Kind == yaml.ScalarNode {
+					g.vars[k] = v.Value
+				}
+			case "children":
+				a.addChild(name, k)
+				if err := a.addYAMLGroup(k, v, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func yamlVars(node *yaml.Node) map[string]string {
+	vars := make(map[string]string)
+	if node.Kind != yaml.MappingNode {
+		return vars
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i+1].Kind == yaml.ScalarNode {
+			vars[node.Content[i].Value] = node.Content[i+1].Value
+		}
+	}
+	return vars
+}
+
+// parseAnsibleJSON reads the output of an Ansible dynamic inventory: groups
+// that are a list of hosts or hold hosts, vars and children, and host
+// variables under _meta.hostvars. JSON objects are unordered, so groups are
+// taken in name order.
+func parseAnsibleJSON(path string, data []byte) (*inventory, error) {
+	top := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	names := make([]string, 0, len(top))
+	for name := range top {
+		if name != "_meta" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	a := newAnsibleInventory()
+	for _, name := range names {
+		group := struct {
+			Hosts    []string               `json:"hosts"`
+			Vars     map[string]interface{} `json:"vars"`
+			Children []string               `json:"children"`
+		}{}
+		if err := json.Unmarshal(top[name], &group.Hosts); err != nil {
+			if err := json.Unmarshal(top[name], &group); err != nil {
+				return nil, fmt.Errorf("%s: group %s: %v", path, name, err)
+			}
+		}
+		g := a.group(name)
+		for _, h := range group.Hosts {
+			a.addHost(name, h, nil)
+		}
+		for k, v := range group.Vars {
+			g.vars[k] = fmt.Sprint(v)
+		}
+		for _, c := range group.Children {
+			a.addChild(name, c)
+		}
+	}
+
+	meta := struct {
+		HostVars map[string]map[string]interface{} `json:"hostvars"`
+	}{}
+	if raw, ok := top["_meta"]; ok {
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, fmt.Errorf("%s: _meta: %v", path, err)
+		}
+	}
+	for h, vars := range meta.HostVars {
+		if a.hostVars[h] == nil {
+			a.hostVars[h] = make(map[string]string)
+		}
+		for k, v := range vars {
+			a.hostVars[h][k] = fmt.Sprint(v)
+		}
+	}
+	return a.inventory(path)
+}
+
+func isAnsibleYAML(data []byte) bool {
+	top, err := gyaml.Decode(data)
+	if err != nil {
+		return false
+	}
+	m, ok := top.(map[string]interface{})
+	return ok && isAnsibleShape(m)
+}
+
+// isAnsibleShape tells an Ansible inventory from jump's own, whose top level
+// only has defaults, groups and a list of hosts.
+func isAnsibleShape(top map[string]interface{}) bool {
+	if _, ok := top["_meta"]; ok {
+		return true
+	}
+	if _, ok := top["all"]; ok {
+		return true
+	}
+	for key, value := range top {
+		switch v := value.(type) {
+		case []interface{}:
+			if key != "hosts" && key != "groups" {
+				return true
+			}
+		case map[string]interface{}:
+			if key == "defaults" {
+				continue
+			}
+			for _, k := range []string{"hosts", "children", "vars"} {
+				if _, ok := v[k]; ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// maxPatternHosts bounds the names a host pattern expands to, so that a typo
+// such as web[1:99999999] is reported instead of exhausting memory.
+const maxPatternHosts = 10000
+
+// expandHostPattern expands Ansible's web[01:20].example.com and db-[a:c]
+// ranges, keeping the zero padding of numeric bounds.
+func expandHostPattern(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "[")
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[start:], "]")
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated range in %q", pattern)
+	}
+	end += start
+	bounds := strings.Split(pattern[start+1:end], ":")
+	if len(bounds) < 2 || len(bounds) > 3 {
+		return nil, fmt.Errorf("invalid range in %q", pattern)
+	}
+	step := 1
+	if len(bounds) == 3 {
+		n, err := strconv.Atoi(bounds[2])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid range step in %q", pattern)
+		}
+		step = n
+	}
+
+	from, errFrom := strconv.Atoi(bounds[0])
+	to, errTo := strconv.Atoi(bounds[1])
+	numeric := errFrom == nil && errTo == nil
+	if !numeric {
+		if len(bounds[0]) != 1 || len(bounds[1]) != 1 {
+			return nil, fmt.Errorf("invalid range in %q", pattern)
+		}
+		from, to = int(bounds[0][0]), int(bounds[1][0])
+	}
+	if to >= from && (to-from)/step >= maxPatternHosts {
+		return nil, fmt.Errorf("range in %q expands to more than %d hosts", pattern, maxPatternHosts)
+	}
+	items := make([]string, 0)
+	for k := 0; to >= from && k <= (to-from)/step; k++ {
+		i := from + k*step
+		if numeric {
+			items = append(items, fmt.Sprintf("%0*d", len(bounds[0]), i))
+		} else {
+			items = append(items, string(rune(i)))
+		}
+	}
+
+	rest, err := expandHostPattern(pattern[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(items)*len(rest) > maxPatternHosts {
+		return nil, fmt.Errorf("%q expands to more than %d hosts", pattern, maxPatternHosts)
+	}
+	names := make([]string, 0, len(items)*len(rest))
+	for _, item := range items {
+		for _, r := range rest {
+			names = append(names, pattern[:start]+item+r)
+		}
+	}
+	return names, nil
+}
+
+// shellFields splits s like a POSIX shell does, honoring single and double
+// quotes and backslash escapes.
+func shellFields(s string) ([]string, error) {
+	fields := make([]string, 0)
+	var cur strings.Builder
+	var quote rune
+	hasField, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			hasField = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			hasField = true
+		case r == ' ' || r == '\t':
+			if hasField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				hasField = false
+			}
+		default:
+			cur.WriteRune(r)
+			hasField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if hasField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
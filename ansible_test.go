@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandHostPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{"web.example.com", []string{"web.example.com"}, false},
+		{"web[1:3]", []string{"web1", "web2", "web3"}, false},
+		{"web[01:03].example.com", []string{"web01.example.com", "web02.example.com", "web03.example.com"}, false},
+		{"web[8:11]", []string{"web8", "web9", "web10", "web11"}, false},
+		{"web[008:010]", []string{"web008", "web009", "web010"}, false},
+		{"web[0:10:5]", []string{"web0", "web5", "web10"}, false},
+		{"db-[a:c]", []string{"db-a", "db-b", "db-c"}, false},
+		{"db-[x:z:2]", []string{"db-x", "db-z"}, false},
+		{"[a:b][1:2]", []string{"a1", "a2", "b1", "b2"}, false},
+		{"web[3:1]", []string{}, false},
+		{"db-[z:a]", []string{}, false},
+		{"web[1:3", nil, true},
+		{"web[1]", nil, true},
+		{"web[1:2:3:4]", nil, true},
+		{"web[1:5:0]", nil, true},
+		{"web[1:5:x]", nil, true},
+		{"web[a:10]", nil, true},
+		{"web[aa:zz]", nil, true},
+		{"web[1:99999999]", nil, true},
+		{fmt.Sprintf("web[1:%d]", maxPatternHosts), nil, false},
+		{fmt.Sprintf("web[0:%d]", maxPatternHosts), nil, true},
+		{"web[1:1000][1:100]", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := expandHostPattern(tt.pattern)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandHostPattern(%q) error %v, want error %v", tt.pattern, err, tt.wantErr)
+			continue
+		}
+		if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandHostPattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestShellFields(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"a b  c", []string{"a", "b", "c"}, false},
+		{`-o "ProxyCommand ssh -W %h:%p bastion"`, []string{"-o", "ProxyCommand ssh -W %h:%p bastion"}, false},
+		{`'it'"'"'s' x\ y`, []string{"it's", "x y"}, false},
+		{`""`, []string{""}, false},
+		{`"open`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := shellFields(tt.s)
+		if (err != nil) != tt.wantErr || err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("shellFields(%q) = %q, %v, want %q, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestProxyCommandJump(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"ssh -W %h:%p bastion", "bastion"},
+		{"ssh -q -W %h:%p -p 2222 -l ops bastion", "ops@bastion:2222"},
+		{"ssh -l ops -W %h:%p root@bastion", "root@bastion"},
+		{"ssh -W %h:22 bastion", ""},
+		{"ssh bastion nc %h %p", ""},
+		{"ssh -A -W %h:%p bastion", ""},
+		{"nc -X 5 -x proxy %h %p", ""},
+	}
+	for _, tt := range tests {
+		if got := proxyCommandJump(tt.command); got != tt.want {
+			t.Errorf("proxyCommandJump(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+// describeInventory resolves every host of inv as jump would and returns one
+// "groups alias user@hostname:port identity bastion options" line per host.
+func describeInventory(t *testing.T, inv *inventory) []string {
+	t.Helper()
+	c, err := inv.config("inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]string, 0)
+	for _, a := range c.aliases() {
+		h, err := c.host(a.name, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extra := make([]string, 0)
+		for _, o := range h.resolved {
+			switch o.key {
+			case "hostname", "user", "port", "identityfile", "proxyjump":
+			default:
+				extra = append(extra, o.name+"="+o.value)
+			}
+		}
+		lines = append(lines, strings.TrimSpace(fmt.Sprintf("%s %s %s@%s:%d %s %s %s",
+			strings.Join(a.groups, "/"), a.name, h.User, h.HostName, h.Port,
+			strings.Join(h.identityFiles, ","), h.ProxyJump, strings.Join(extra, ","))))
+	}
+	return lines
+}
+
+func TestParseAnsibleINI(t *testing.T) {
+	data := `
+# comment
+; another comment
+bastion ansible_host=203.0.113.9 ansible_user=jump
+
+[web]
+web[01:02] ansible_host=10.0.0.1 # front ends
+web3.example.com:2200
+web[4:5].example.com:2201 ansible_user=www # "quoted # text"
+web6.example.com:2202 ansible_port=2203
+'web#7' ansible_host=10.0.0.7
+[fe80::8]:2204
+fe80::9
+
+[db]
+db1 ansible_host=10.0.1.1 ansible_port=5432 ansible_user=postgres
+
+[prod:children]
+web # front ends
+db
+
+[prod:vars]
+ansible_user=deploy
+ansible_ssh_private_key_file=~/.ssh/prod
+ansible_ssh_common_args='-o ProxyCommand="ssh -W %h:%p -q bastion" -o ServerAliveInterval=30'
+
+[all:vars]
+ansible_port=2222
+`
+	inv, err := parseAnsibleINI("hosts", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"bastion jump@203.0.113.9:2222",
+		"prod/web web01 deploy@10.0.0.1:2222 ~/.ssh/prod bastion ServerAliveInterval=30",
+		"prod/web web02 deploy@10.0.0.1:2222 ~/.ssh/prod bastion ServerAliveInterval=30",
+		"prod/web web3.example.com deploy@web3.example.com:2200 ~/.ssh/prod bastion ServerAliveInterval=30",
+		"prod/web web4.example.com www@web4.example.com:2201 ~/.ssh/prod bastion ServerAliveInterval=30",
+		"prod/web web5.example.com www@web5.example.com:2201 ~/.ssh/prod bastion ServerAliveInterval=30",
+		"prod/web web6.example.com deploy@web6.example.com:2203 ~/.ssh/prod bastion ServerAliveInterval=30",
+		"prod/web web#7 deploy@10.0.0.7:2222 ~/.ssh/prod bastion ServerAliveInterval=30",
+		"prod/web fe80::8 deploy@fe80::8:2204 ~/.ssh/prod bastion ServerAliveInterval=30",
+		"prod/web fe80::9 deploy@fe80::9:2222 ~/.ssh/prod bastion ServerAliveInterval=30",
+		"prod/db db1 postgres@10.0.1.1:5432 ~/.ssh/prod bastion ServerAliveInterval=30",
+	}
+	if got := describeInventory(t, inv); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAnsibleINI:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseAnsibleINIErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"[web:hosts]\nweb1", "hosts:1: unknown section type"},
+		{"[web:vars]\nansible_user", "hosts:2: expected key=value"},
+		{"[web]\nweb1 ansible_user", `hosts:2: expected key=value, got "ansible_user"`},
+		{"[web]\nweb[1:", "hosts:2: unterminated range"},
+		{"[web]\nweb1 ansible_port=ssh", "host web1: invalid ansible_port"},
+		{"[web]\nweb1 'open", "hosts:2:"},
+	}
+	for _, tt := range tests {
+		_, err := parseAnsibleINI("hosts", []byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseAnsibleINI(%q) error %v, want %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestParseAnsibleYAMLAndJSON(t *testing.T) {
+	yamlData := `
+all:
+  vars:
+    ansible_user: deploy
+  hosts:
+    bastion:
+      ansible_host: 203.0.113.9
+  children:
+    prod:
+      children:
+        web:
+          hosts:
+            web01:
+              ansible_host: 10.0.0.1
+              ansible_ssh_common_args: -J bastion
+`
+	jsonData := `{
+  "_meta": {"hostvars": {
+    "web01": {"ansible_host": "10.0.0.1", "ansible_ssh_common_args": "-J bastion"},
+    "bastion": {"ansible_host": "203.0.113.9"}
+  }},
+  "all": {"vars": {"ansible_user": "deploy"}, "children": ["prod", "ungrouped"]},
+  "prod": {"children": ["web"]},
+  "web": {"hosts": ["web01"]},
+  "ungrouped": {"hosts": ["bastion"]}
+}`
+	want := []string{
+		"bastion deploy@203.0.113.9:22",
+		"prod/web web01 deploy@10.0.0.1:22  bastion",
+	}
+	for _, tt := range []struct {
+		path string
+		data string
+	}{
+		{"hosts.yml", yamlData},
+		{"hosts.json", jsonData},
+	} {
+		if !strings.HasSuffix(tt.path, ".json") && !isAnsibleYAML([]byte(tt.data)) {
+			t.Errorf("%s: not recognized as YAML", tt.path)
+		}
+		inv, err := decodeAnsible(tt.path, []byte(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if got := describeInventory(t, inv); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.path, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type identity struct {
+	file   string
+	data   []byte
+	public ssh.PublicKey
+	raw    interface{}
+	signer ssh.Signer
+}
+
+var (
+	identityCache = make(map[string]*identity)
+	identityLock  = &sync.Mutex{}
+)
+
+// loadIdentity accepts either a private key or, for keys that only live in an
+// agent, the matching public key. Loaded identities are cached for the life of
+// the process so a passphrase is asked for at most once.
+func loadIdentity(file string) (*identity, error) {
+	identityLock.Lock()
+	defer identityLock.Unlock()
+	if id, ok := identityCache[file]; ok {
+		return id, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	id := &identity{file: file}
+	if pub, _, _, _, err := ssh.ParseAuthorizedKey(data); err == nil {
+		id.public = pub
+		identityCache[file] = id
+		return id, nil
+	}
+
+	raw, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case errors.As(err, &missing):
+		id.data = data
+		id.public = missing.PublicKey
+		if id.public == nil {
+			id.public = readPublicKey(file + ".pub")
+		}
+		if id.public == nil {
+			if err := id.decrypt(); err != nil {
+				return nil, err
+			}
+			if id.signer == nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+		}
+	case err != nil:
+		return nil, fmt.Errorf("%s: %w", file, err)
+	default:
+		if err := id.setKey(raw); err != nil {
+			return nil, err
+		}
+	}
+	identityCache[file] = id
+	return id, nil
+}
+
+func readPublicKey(file string) ssh.PublicKey {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+func (id *identity) setKey(raw interface{}) error {
+	signer, err := ssh.NewSignerFromKey(raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", id.file, err)
+	}
+	id.raw = raw
+	id.signer = signer
+	id.public = signer.PublicKey()
+	id.data = nil
+	return nil
+}
+
+// decrypt asks for the passphrase of an encrypted key, giving up after three
+// wrong attempts. An empty passphrase skips the key like OpenSSH does.
+func (id *identity) decrypt() error {
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(os.Stderr, "Enter passphrase for key '%s': ", id.file)
+		passphrase, err := readPassword()
+		fmt.Fprint(os.Stderr, "\r\n")
+		if err != nil {
+			return err
+		}
+		if len(passphrase) == 0 {
+			return nil
+		}
+		raw, err := ssh.ParseRawPrivateKeyWithPassphrase(id.data, passphrase)
+		if err == x509.IncorrectPasswordError {
+			fmt.Fprint(os.Stderr, "Bad passphrase, try again.\r\n")
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", id.file, err)
+		}
+		return id.setKey(raw)
+	}
+	return fmt.Errorf("%s: %w", id.file, x509.IncorrectPasswordError)
+}
+
+func (h *Host) agentSocket() string {
+	sock := h.IdentityAgent
+	switch strings.ToLower(sock) {
+	case "", "ssh_auth_sock":
+		return os.Getenv("SSH_AUTH_SOCK")
+	case "none":
+		return ""
+	}
+	if strings.HasPrefix(sock, "$") {
+		return os.Getenv(strings.Trim(sock[1:], "{}"))
+	}
+	return h.expandPath(sock)
+}
+
+func (h *Host) dialAgent() (agent.ExtendedAgent, net.Conn) {
+	sock := h.agentSocket()
+	if sock == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect to agent %s: %v\r\n", sock, err)
+		return nil, nil
+	}
+	return agent.NewClient(conn), conn
+}
+
+var defaultIdentityFiles = []string{"id_rsa", "id_ecdsa", "id_ecdsa_sk", "id_ed25519", "id_ed25519_sk", "id_xmss", "id_dsa"}
+
+// identities loads every configured IdentityFile in order, or the OpenSSH
+// default keys when none is configured. Keys that cannot be loaded are skipped
+// with a warning; missing default keys are skipped silently.
+func (h *Host) identities() []*identity {
+	files := h.identityFiles
+	configured := len(files) > 0
+	if !configured {
+		for _, name := range defaultIdentityFiles {
+			files = append(files, filepath.Join("~", ".ssh", name))
+		}
+	}
+
+	ids := make([]*identity, 0, len(files))
+	for _, f := range files {
+		id, err := loadIdentity(h.expandPath(f))
+		if err != nil {
+			if configured || !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Warning: skip identity %s: %v\r\n", f, err)
+			}
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// signers returns the public key signers offered to the server: agent keys
+// first, then identity files, the same order OpenSSH uses, each preceded by its
+// certificate when one is available. The returned func releases the agent
+// connection and must be called once authentication is done.
+func (h *Host) signers() ([]ssh.Signer, func()) {
+	ids := h.identities()
+
+	signers := make([]ssh.Signer, 0)
+	ag, conn := h.dialAgent()
+	closeAgent := func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}
+	if ag != nil {
+		agentSigners, err := ag.Signers()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "list agent keys: %v\r\n", err)
+		}
+		for _, s := range agentSigners {
+			if isYes(h.IdentitiesOnly) && !hasPublicKey(ids, s.PublicKey()) {
+				continue
+			}
+			signers = append(signers, s)
+		}
+	}
+
+	for _, id := range ids {
+		if hasSigner(signers, id.public) {
+			continue
+		}
+		if id.signer == nil && id.data != nil {
+			if err := id.decrypt(); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\r\n", err)
+				continue
+			}
+		}
+		if id.signer == nil {
+			continue
+		}
+		signers = append(signers, id.signer)
+		if ag != nil {
+			if err := h.addKeyToAgent(ag, id); err != nil {
+				fmt.Fprintf(os.Stderr, "add %s to agent: %v\r\n", id.file, err)
+			}
+		}
+	}
+
+	return withCertificates(signers, h.certificates(ids)), closeAgent
+}
+
+const defaultPreferredAuthentications = "publickey,keyboard-interactive,password"
+
+// authMethods builds the auth methods in PreferredAuthentications order. The
+// ssh client moves on to the next method the server still accepts whenever one
+// fails, which matches OpenSSH's fallback behavior.
+func (h *Host) authMethods() ([]ssh.AuthMethod, func()) {
+	prompts := h.NumberOfPasswordPrompts
+	if prompts <= 0 {
+		prompts = 3
+	}
+
+	closeAgent := func() {}
+	methods := make([]ssh.AuthMethod, 0)
+	for _, name := range strings.Split(h.preferredAuthentications(), ",") {
+		switch strings.TrimSpace(name) {
+		case "publickey":
+			if isNo(h.PubkeyAuthentication) {
+				continue
+			}
+			signers, closer := h.signers()
+			closeAgent = closer
+			if len(signers) == 0 {
+				continue
+			}
+			methods = append(methods, ssh.PublicKeys(signers...))
+		case "keyboard-interactive":
+			if isNo(h.KbdInteractiveAuthentication) || isNo(h.ChallengeResponseAuthentication) {
+				continue
+			}
+			methods = append(methods, ssh.RetryableAuthMethod(ssh.KeyboardInteractive(keyboardInteractive), prompts))
+		case "password":
+			if isNo(h.PasswordAuthentication) {
+				continue
+			}
+			methods = append(methods, ssh.RetryableAuthMethod(ssh.PasswordCallback(h.password), prompts))
+		}
+	}
+	return methods, closeAgent
+}
+
+func (h *Host) preferredAuthentications() string {
+	if h.PreferredAuthentications == "" {
+		return defaultPreferredAuthentications
+	}
+	return h.PreferredAuthentications
+}
+
+func (h *Host) password() (string, error) {
+	fmt.Fprintf(os.Stderr, "%s@%s's password: ", h.User, h.HostName)
+	password, err := readPassword()
+	fmt.Fprint(os.Stderr, "\r\n")
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+func keyboardInteractive(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if name != "" {
+		fmt.Fprintf(os.Stderr, "%s\r\n", name)
+	}
+	if instruction != "" {
+		fmt.Fprintf(os.Stderr, "%s\r\n", instruction)
+	}
+
+	answers := make([]string, len(questions))
+	for i, q := range questions {
+		if echos[i] {
+			answer, err := readLine(q)
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+			continue
+		}
+		fmt.Fprint(os.Stderr, q)
+		answer, err := readPassword()
+		fmt.Fprint(os.Stderr, "\r\n")
+		if err != nil {
+			return nil, err
+		}
+		answers[i] = string(answer)
+	}
+	return answers, nil
+}
+
+func isYes(s string) bool {
+	return strings.ToLower(s) == "yes"
+}
+
+func isNo(s string) bool {
+	return strings.ToLower(s) == "no"
+}
+
+// addKeyToAgent implements AddKeysToAgent: yes, no, ask or confirm, optionally
+// followed by a key lifetime such as 1h or 3600.
+func (h *Host) addKeyToAgent(ag agent.ExtendedAgent, id *identity) error {
+	key := agent.AddedKey{PrivateKey: id.raw, Comment: id.file}
+	add := false
+	for _, f := range strings.Fields(strings.ToLower(h.AddKeysToAgent)) {
+		switch f {
+		case "yes":
+			add = true
+		case "no":
+			return nil
+		case "confirm":
+			add = true
+			key.ConfirmBeforeUse = true
+		case "ask":
+			answer, err := readLine(fmt.Sprintf("Add key %s (%s) to agent? (yes/no) ", id.file, ssh.FingerprintSHA256(id.public)))
+			if err != nil {
+				return err
+			}
+			if answer != "yes" {
+				return nil
+			}
+			add = true
+		default:
+			lifetime, err := parseLifetime(f)
+			if err != nil {
+				return err
+			}
+			add = true
+			key.LifetimeSecs = uint32(lifetime / time.Second)
+		}
+	}
+	if !add {
+		return nil
+	}
+	return ag.Add(key)
+}
+
+func parseLifetime(s string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(s); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func hasPublicKey(ids []*identity, key ssh.PublicKey) bool {
+	for _, id := range ids {
+		if bytes.Equal(id.public.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSigner(signers []ssh.Signer, key ssh.PublicKey) bool {
+	for _, s := range signers {
+		if bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certificates loads the user certificates from CertificateFile and from the
+// <IdentityFile>-cert.pub file next to each identity. Certificates outside
+// their validity window are reported and left out.
+func (h *Host) certificates(ids []*identity) []*ssh.Certificate {
+	type certFile struct {
+		path       string
+		configured bool
+	}
+	files := make([]certFile, 0)
+	for _, f := range h.certificateFiles {
+		files = append(files, certFile{path: h.expandPath(f), configured: true})
+	}
+	for _, id := range ids {
+		files = append(files, certFile{path: strings.TrimSuffix(id.file, ".pub") + "-cert.pub"})
+	}
+
+	certs := make([]*ssh.Certificate, 0)
+	now := time.Now()
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			if f.configured || !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Warning: skip certificate %s: %v\r\n", f.path, err)
+			}
+			continue
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skip certificate %s: %v\r\n", f.path, err)
+			continue
+		}
+		cert, ok := pub.(*ssh.Certificate)
+		if !ok || cert.CertType != ssh.UserCert {
+			fmt.Fprintf(os.Stderr, "Warning: skip certificate %s: not a user certificate\r\n", f.path)
+			continue
+		}
+		if !certValidAt(cert, now) {
+			fmt.Fprintf(os.Stderr, "Warning: certificate %s is only valid from %s to %s\r\n", f.path, certTime(cert.ValidAfter), certTime(cert.ValidBefore))
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+func certValidAt(cert *ssh.Certificate, t time.Time) bool {
+	unix := uint64(t.Unix())
+	return unix >= cert.ValidAfter && (cert.ValidBefore == ssh.CertTimeInfinity || unix < cert.ValidBefore)
+}
+
+func certTime(t uint64) string {
+	switch t {
+	case 0:
+		return "always"
+	case ssh.CertTimeInfinity:
+		return "forever"
+	}
+	return time.Unix(int64(t), 0).Format("2006-01-02 15:04:05")
+}
+
+// withCertificates offers a certificate signer right before the plain signer
+// of the key it certifies, like OpenSSH does.
+func withCertificates(signers []ssh.Signer, certs []*ssh.Certificate) []ssh.Signer {
+	result := make([]ssh.Signer, 0, len(signers)+len(certs))
+	for _, s := range signers {
+		for _, cert := range certs {
+			if !bytes.Equal(cert.Key.Marshal(), s.PublicKey().Marshal()) || hasSigner(result, cert) {
+				continue
+			}
+			certSigner, err := ssh.NewCertSigner(cert, s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skip certificate %s: %v\r\n", ssh.FingerprintSHA256(cert), err)
+				continue
+			}
+			result = append(result, certSigner)
+		}
+		result = append(result, s)
+	}
+	return result
+}
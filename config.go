@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/gogf/gf/util/gconv"
+)
+
+const maxIncludeDepth = 16
+
+// multiValueOptions may be given several times and every value is kept, in
+// order. For all other options the first value obtained wins.
+var multiValueOptions = map[string]bool{
+	"certificatefile": true,
+	"dynamicforward":  true,
+	"identityfile":    true,
+	"localforward":    true,
+	"remoteforward":   true,
+}
+
+// configOption is an option line: key is the lower case name used to look
+// the option up, name is the name as written.
+type configOption struct {
+	key   string
+	name  string
+	value string
+}
+
+// configBlock is a Host or Match section, or the options before the first of
+// them, which apply to every host.
+type configBlock struct {
+	kind    string
+	args    []string
+	comment string
+	file    string
+	options []configOption
+	groups  []string
+	tags    map[string]string
+}
+
+// sshConfig is one config file with its includes, or the layers of several
+// files loaded together.
+type sshConfig struct {
+	blocks    []*configBlock
+	layers    []*sshConfig
+	entries   []*configAlias
+	matchExec bool
+}
+
+type configAlias struct {
+	name    string
+	comment string
+	file    string
+	groups  []string
+	tags    map[string]string
+}
+
+// configPaths returns the config files given with -F, or else listed in
+// JUMP_CONFIG, or else the user's ~/.ssh/config.
+func configPaths(flagPaths []string) []string {
+	if len(flagPaths) > 0 {
+		return flagPaths
+	}
+	if env := os.Getenv("JUMP_CONFIG"); env != "" {
+		return filepath.SplitList(env)
+	}
+	return []string{filepath.Join(os.Getenv("HOME"), ".ssh", "config")}
+}
+
+// loadSSHConfig layers the given files. An alias keeps the position of its
+// first definition and is attributed to the last file that names it; a later
+// file only changes the comment, tags and groups it sets.
+func loadSSHConfig(paths ...string) (*sshConfig, error) {
+	c := &sshConfig{}
+	entries := make(map[string]*configAlias)
+	for _, path := range paths {
+		layer, err := loadConfigLayer(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range layer.aliases() {
+			if prev, ok := entries[a.name]; ok {
+				prev.merge(a)
+				continue
+			}
+			entries[a.name] = a
+			c.entries = append(c.entries, a)
+		}
+		c.layers = append(c.layers, layer)
+		c.matchExec = c.matchExec || layer.matchExec
+	}
+	return c, nil
+}
+
+// merge overlays a later definition of the alias on a.
+func (a *configAlias) merge(later *configAlias) {
+	tags, comment := parseComment(a.comment)
+	laterTags, laterComment := parseComment(later.comment)
+	a.tags = mergeTags(mergeTags(a.tags, tags), mergeTags(later.tags, laterTags))
+	a.comment = comment
+	if laterComment != "" {
+		a.comment = laterComment
+	}
+	if len(later.groups) > 0 {
+		a.groups = later.groups
+	}
+	a.file = later.file
+}
+
+func loadConfigLayer(path string) (*sshConfig, error) {
+	if isInventoryFile(path) {
+		return loadInventory(path)
+	}
+	if isInventoryScript(path) {
+		return loadInventoryScript(path)
+	}
+	layer := &sshConfig{}
+	block := &configBlock{file: path}
+	layer.blocks = append(layer.blocks, block)
+	if err := layer.parseFile(expandHome(path), block, 0); err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+func (c *sshConfig) parseFile(path string, block *configBlock, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: too many nested Include directives", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		name, args, value, comment := parseConfigLine(scanner.Text())
+		if name == "" {
+			continue
+		}
+		key := strings.ToLower(name)
+
+		switch key {
+		case "host", "match":
+			if len(args) == 0 {
+				return fmt.Errorf("%s:%d: %s directive without arguments", path, lineNum, key)
+			}
+			block = &configBlock{kind: key, args: args, comment: comment, file: path}
+			c.blocks = append(c.blocks, block)
+			for _, arg := range args {
+				if key == "match" && strings.TrimPrefix(strings.ToLower(arg), "!") == "exec" {
+					c.matchExec = true
+				}
+			}
+		case "include":
+			for _, pattern := range args {
+				matches, err := filepath.Glob(includePath(pattern))
+				if err != nil {
+					return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+				}
+				for _, m := range matches {
+					if err := c.parseFile(m, block, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			block.options = append(block.options, configOption{key: key, name: name, value: value})
+		}
+	}
+	return scanner.Err()
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~") {
+		return os.Getenv("HOME") + path[1:]
+	}
+	return path
+}
+
+// includePath resolves an Include argument; relative paths are taken from
+// ~/.ssh like OpenSSH does for user configs.
+func includePath(pattern string) string {
+	pattern = expandHome(pattern)
+	if filepath.IsAbs(pattern) {
+		return pattern
+	}
+	return filepath.Join(os.Getenv("HOME"), ".ssh", pattern)
+}
+
+// parseConfigLine splits a "Key value" or "Key=value" line. The key is
+// returned as written; a " # remark" at the end of the line is returned as
+// the comment.
+func parseConfigLine(line string) (string, []string, string, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, "", ""
+	}
+
+	comment := ""
+	inQuote := false
+	for i, r := range line {
+		if r == '"' {
+			inQuote = !inQuote
+		}
+		if r == '#' && !inQuote && i > 0 && (line[i-1] == ' ' || line[i-1] == '\t') {
+			comment = strings.TrimSpace(line[i+1:])
+			line = strings.TrimSpace(line[:i])
+			break
+		}
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return line, nil, "", comment
+	}
+	key := line[:end]
+	value := strings.TrimSpace(line[end:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+
+	args := splitArgs(value)
+	if len(args) == 1 {
+		value = args[0]
+	}
+	return key, args, value, comment
+}
+
+func splitArgs(s string) []string {
+	args := make([]string, 0)
+	var cur strings.Builder
+	inQuote, hasArg := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuote:
+			if hasArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				hasArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, cur.String())
+	}
+	return args
+}
+
+// aliases returns every concrete alias named on a Host line, in file order.
+// Wildcard and negated patterns only contribute options, not menu entries.
+func (c *sshConfig) aliases() []*configAlias {
+	seen := make(map[string]bool)
+	aliases := make([]*configAlias, 0)
+	for _, b := range c.blocks {
+		if b.kind != "host" {
+			continue
+		}
+		for _, p := range b.args {
+			if strings.ContainsAny(p, "*?!") || seen[p] {
+				continue
+			}
+			seen[p] = true
+			aliases = append(aliases, &configAlias{name: p, comment: b.comment, file: b.file, groups: b.groups, tags: b.tags})
+		}
+	}
+	return aliases
+}
+
+// host resolves alias the way OpenSSH does within a file: blocks are applied
+// in order and the first value obtained for an option wins. Across files, the
+// blocks of a later file that name the alias win over the earlier files,
+// while its wildcard blocks only fill in the options left unset. Options that
+// take several values get those of the later files first. Match exec
+// criteria only run when exec is set, as they run commands; until then their
+// blocks are skipped.
+func (c *sshConfig) host(alias string, exec bool) (*Host, error) {
+	layers := c.layers
+	if len(layers) == 0 {
+		layers = []*sshConfig{c}
+	}
+	values := make(map[string]string)
+	options := make([]configOption, 0)
+	set := func(from []configOption) {
+		for _, o := range from {
+			if _, ok := values[o.key]; !ok {
+				values[o.key] = o.value
+				options = append(options, o)
+			}
+		}
+	}
+	lists := make([]configOption, 0)
+	fallbacks := make([][]configOption, 0, len(layers))
+	for i := len(layers) - 1; i >= 0; i-- {
+		named, wild, layerLists, err := layers[i].resolve(alias, exec)
+		if err != nil {
+			return nil, err
+		}
+		set(named)
+		fallbacks = append(fallbacks, wild)
+		lists = append(lists, layerLists...)
+	}
+	for _, wild := range fallbacks {
+		set(wild)
+	}
+
+	h := newHost(alias)
+	if len(values) > 0 {
+		if err := gconv.Struct(values, h); err != nil {
+			return nil, err
+		}
+	}
+	h.HostName = strings.ReplaceAll(h.HostName, "%h", alias)
+	for _, o := range lists {
+		switch o.key {
+		case "identityfile":
+			h.identityFiles = append(h.identityFiles, o.value)
+		case "certificatefile":
+			h.certificateFiles = append(h.certificateFiles, o.value)
+		case "localforward":
+			h.localForwards = append(h.localForwards, o.value)
+		case "remoteforward":
+			h.remoteForwards = append(h.remoteForwards, o.value)
+		case "dynamicforward":
+			h.dynamicForwards = append(h.dynamicForwards, o.value)
+		}
+	}
+	h.resolved = append(options, lists...)
+	return h, nil
+}
+
+// resolve applies the blocks of one file to alias. The options set by a Host
+// block naming the alias are returned apart from those set by wildcard Host
+// blocks, Match blocks and the options before the first block, and from the
+// values of the options that take several. When a Match line asks for the
+// final pass, the blocks are applied a second time with final matching, like
+// OpenSSH does.
+func (c *sshConfig) resolve(alias string, exec bool) ([]configOption, []configOption, []configOption, error) {
+	values := make(map[string]string)
+	named := make([]configOption, 0)
+	wild := make([]configOption, 0)
+	lists := make([]configOption, 0)
+
+	hasFinal := false
+	for pass := 0; pass < 2; pass++ {
+		final := pass == 1
+		if final && !hasFinal {
+			break
+		}
+		for _, b := range c.blocks {
+			switch b.kind {
+			case "host":
+				if !matchPatternList(b.args, alias) {
+					continue
+				}
+			case "match":
+				for _, arg := range b.args {
+					hasFinal = hasFinal || strings.TrimPrefix(strings.ToLower(arg), "!") == "final"
+				}
+				ok, err := matchCriteria(b.args, alias, values, final, exec)
+				if err != nil {
+					return nil, nil, nil, fmt.Errorf("%s: %v", b.file, err)
+				}
+				if !ok {
+					continue
+				}
+			}
+			isNamed := b.kind == "host" && namesAlias(b.args, alias)
+			for _, o := range b.options {
+				if multiValueOptions[o.key] {
+					if !final || !containsOption(lists, o) {
+						lists = append(lists, o)
+					}
+					continue
+				}
+				if _, ok := values[o.key]; ok {
+					continue
+				}
+				values[o.key] = o.value
+				if isNamed {
+					named = append(named, o)
+				} else {
+					wild = append(wild, o)
+				}
+			}
+		}
+	}
+	return named, wild, lists, nil
+}
+
+func containsOption(list []configOption, o configOption) bool {
+	for _, x := range list {
+		if x.key == o.key && x.value == o.value {
+			return true
+		}
+	}
+	return false
+}
+
+// namesAlias tells whether a Host line lists alias itself rather than only a
+// pattern that matches it.
+func namesAlias(patterns []string, alias string) bool {
+	for _, p := range patterns {
+		if strings.EqualFold(p, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveHost returns a copy of the menu host named alias, or resolves alias
+// from the loaded config when it is only matched by patterns.
+func resolveHost(alias string) (*Host, error) {
+	for _, h := range hosts {
+		if h.Host == alias {
+			copied := *h
+			return copied.withExec()
+		}
+	}
+	if sshCfg == nil {
+		return newHost(alias), nil
+	}
+	return sshCfg.host(alias, true)
+}
+
+// withExec resolves h again with its Match exec criteria run, which is only
+// done when connecting. What jump adds to the host, such as its ID, env,
+// tags and groups, is kept.
+func (h *Host) withExec() (*Host, error) {
+	if sshCfg == nil || !sshCfg.matchExec {
+		return h, nil
+	}
+	r, err := sshCfg.host(h.Host, true)
+	if err != nil {
+		return nil, err
+	}
+	r.Index, r.Env, r.Comment, r.Source = h.Index, h.Env, h.Comment, h.Source
+	r.Tags, r.Fields, r.groups, r.hosts = h.Tags, h.Fields, h.groups, h.hosts
+	return r, nil
+}
+
+// matchCriteria evaluates the all, canonical, final, host, originalhost,
+// user, localuser and exec criteria of a Match line against the options
+// resolved so far. Host names are never canonicalized, so canonical does not
+// match, and final only matches in the final pass. Unless exec is set, a line
+// with an exec criterion does not match.
+func matchCriteria(args []string, alias string, values map[string]string, final, exec bool) (bool, error) {
+	h := newHost(alias)
+	if len(values) > 0 {
+		if err := gconv.Struct(values, h); err != nil {
+			return false, err
+		}
+	}
+	h.HostName = strings.ReplaceAll(h.HostName, "%h", alias)
+
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negate := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		var ok bool
+		switch criterion {
+		case "all":
+			ok = true
+		case "canonical":
+			ok = false
+		case "final":
+			ok = final
+		case "host", "originalhost", "user", "localuser", "exec":
+			if i+1 >= len(args) {
+				return false, fmt.Errorf("Match %s requires an argument", criterion)
+			}
+			i++
+			arg := args[i]
+			switch criterion {
+			case "host":
+				ok = matchPatternList(strings.Split(arg, ","), h.HostName)
+			case "originalhost":
+				ok = matchPatternList(strings.Split(arg, ","), alias)
+			case "user":
+				ok = matchPatternList(strings.Split(arg, ","), h.User)
+			case "localuser":
+				ok = matchPatternList(strings.Split(arg, ","), os.Getenv("USER"))
+			case "exec":
+				if !exec {
+					return false, nil
+				}
+				ok = runMatchExec(h.expandPath(arg))
+			}
+		default:
+			return false, fmt.Errorf("unsupported Match criterion %q", criterion)
+		}
+		if ok == negate {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func runMatchExec(command string) bool {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/c", command).Run() == nil
+	}
+	return exec.Command("/bin/sh", "-c", command).Run() == nil
+}
+
+// matchPatternList reports whether s matches the pattern list. A matching
+// negated pattern rejects s regardless of the other patterns.
+func matchPatternList(patterns []string, s string) bool {
+	found := false
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			if matchPattern(p[1:], s) {
+				return false
+			}
+			continue
+		}
+		if matchPattern(p, s) {
+			found = true
+		}
+	}
+	return found
+}
+
+// matchPattern matches s against an ssh_config pattern where * matches any
+// run of characters and ? exactly one. Matching is case-insensitive.
+func matchPattern(pattern, s string) bool {
+	p, str := []rune(strings.ToLower(pattern)), []rune(strings.ToLower(s))
+	pi, si := 0, 0
+	star, mark := -1, 0
+	for si < len(str) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == str[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"web", "web", true},
+		{"web", "WEB", true},
+		{"web", "web1", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"web*", "web01", true},
+		{"web*", "db01", false},
+		{"*.example.com", "a.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"web?", "web1", true},
+		{"web?", "web", false},
+		{"web?", "web12", false},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbc", false},
+		{"a**", "a", true},
+		{"10.0.?.*", "10.0.1.25", true},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPatternList(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		s        string
+		want     bool
+	}{
+		{[]string{"web*", "db*"}, "db1", true},
+		{[]string{"web*", "db*"}, "cache1", false},
+		{[]string{"*", "!bastion"}, "bastion", false},
+		{[]string{"!bastion", "*"}, "bastion", false},
+		{[]string{"*", "!bastion"}, "web", true},
+		{[]string{"!bastion"}, "web", false},
+		{nil, "web", false},
+	}
+	for _, tt := range tests {
+		if got := matchPatternList(tt.patterns, tt.s); got != tt.want {
+			t.Errorf("matchPatternList(%q, %q) = %v, want %v", tt.patterns, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseConfigLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		key     string
+		args    []string
+		value   string
+		comment string
+	}{
+		{"", "", nil, "", ""},
+		{"   # a comment", "", nil, "", ""},
+		{"HostName 10.0.0.1", "HostName", []string{"10.0.0.1"}, "10.0.0.1", ""},
+		{"  Port=2222", "Port", []string{"2222"}, "2222", ""},
+		{"Port = 2222", "Port", []string{"2222"}, "2222", ""},
+		{"\tUser\troot", "User", []string{"root"}, "root", ""},
+		{"Host web_prod # nginx env=prod", "Host", []string{"web_prod"}, "web_prod", "nginx env=prod"},
+		{"Host a b c", "Host", []string{"a", "b", "c"}, "a b c", ""},
+		{`IdentityFile "~/my keys/id_rsa"`, "IdentityFile", []string{"~/my keys/id_rsa"}, "~/my keys/id_rsa", ""},
+		{`ProxyCommand "nc #x" %h %p`, "ProxyCommand", []string{"nc #x", "%h", "%p"}, `"nc #x" %h %p`, ""},
+		{"HostName web#1", "HostName", []string{"web#1"}, "web#1", ""},
+		{"Compression", "Compression", nil, "", ""},
+	}
+	for _, tt := range tests {
+		key, args, value, comment := parseConfigLine(tt.line)
+		if len(args) == 0 {
+			args = nil
+		}
+		if key != tt.key || !reflect.DeepEqual(args, tt.args) || value != tt.value || comment != tt.comment {
+			t.Errorf("parseConfigLine(%q) = %q, %q, %q, %q, want %q, %q, %q, %q",
+				tt.line, key, args, value, comment, tt.key, tt.args, tt.value, tt.comment)
+		}
+	}
+}
+
+func TestMatchCriteria(t *testing.T) {
+	os.Setenv("USER", "me")
+	values := map[string]string{"hostname": "%h.example.com", "user": "deploy"}
+	tests := []struct {
+		args    []string
+		final   bool
+		exec    bool
+		want    bool
+		wantErr bool
+	}{
+		{[]string{"all"}, false, false, true, false},
+		{[]string{"host", "*.example.com"}, false, false, true, false},
+		{[]string{"host", "web"}, false, false, false, false},
+		{[]string{"originalhost", "web"}, false, false, true, false},
+		{[]string{"OriginalHost", "db,web*"}, false, false, true, false},
+		{[]string{"user", "deploy"}, false, false, true, false},
+		{[]string{"!user", "deploy"}, false, false, false, false},
+		{[]string{"localuser", "me"}, false, false, true, false},
+		{[]string{"originalhost", "web", "user", "root"}, false, false, false, false},
+		{[]string{"canonical"}, false, false, false, false},
+		{[]string{"!canonical"}, false, false, true, false},
+		{[]string{"final"}, false, false, false, false},
+		{[]string{"final"}, true, false, true, false},
+		{[]string{"final", "originalhost", "web"}, true, false, true, false},
+		{[]string{"exec", "true"}, false, false, false, false},
+		{[]string{"exec", "true"}, false, true, true, false},
+		{[]string{"exec", "false"}, false, true, false, false},
+		{[]string{"!exec", "false"}, false, true, true, false},
+		{[]string{"host"}, false, false, false, true},
+		{[]string{"address", "10.0.0.0/8"}, false, false, false, true},
+	}
+	for _, tt := range tests {
+		got, err := matchCriteria(tt.args, "web", values, tt.final, tt.exec)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("matchCriteria(%q, final=%v, exec=%v) = %v, %v, want %v, error %v",
+				tt.args, tt.final, tt.exec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHostFirstMatch(t *testing.T) {
+	dir := t.TempDir()
+	first := writeConfig(t, dir, "first", `
+Host web_prod # nginx env=prod
+    HostName 10.0.0.1
+    IdentityFile ~/.ssh/web
+
+Host web_* db_*
+    User deploy
+    Port 2200
+    HostName ignored
+    IdentityFile ~/.ssh/shared
+
+Match originalhost db_* final
+    User dba
+    LogLevel QUIET
+
+Host *
+    User nobody
+    ServerAliveInterval 30
+`)
+	second := writeConfig(t, dir, "second", `
+Host db_prod # postgres #primary
+    HostName 10.0.1.1
+    IdentityFile ~/.ssh/db
+
+Host db_prod
+    Port 5432
+
+Host *
+    Port 2222
+    Compression yes
+`)
+	c, err := loadSSHConfig(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		alias    string
+		hostName string
+		user     string
+		port     int
+		identity []string
+		extra    map[string]string
+		comment  string
+		tags     map[string]string
+	}{
+		{
+			// The wildcard blocks of a later file come before an earlier file's.
+			alias:    "web_prod",
+			hostName: "10.0.0.1",
+			user:     "deploy",
+			port:     2222,
+			identity: []string{"~/.ssh/web", "~/.ssh/shared"},
+			extra:    map[string]string{"serveraliveinterval": "30", "compression": "yes"},
+			comment:  "nginx",
+			tags:     map[string]string{"env": "prod"},
+		},
+		{
+			alias:    "db_prod",
+			hostName: "10.0.1.1",
+			user:     "deploy",
+			port:     5432,
+			identity: []string{"~/.ssh/db", "~/.ssh/shared"},
+			extra:    map[string]string{"serveraliveinterval": "30", "compression": "yes", "loglevel": "QUIET"},
+			comment:  "postgres",
+			tags:     map[string]string{"primary": ""},
+		},
+		{
+			alias:    "other",
+			hostName: "other",
+			user:     "nobody",
+			port:     2222,
+			extra:    map[string]string{"serveraliveinterval": "30"},
+		},
+	}
+	for _, tt := range tests {
+		h, err := c.host(tt.alias, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alias, err)
+		}
+		if h.HostName != tt.hostName || h.User != tt.user || h.Port != tt.port {
+			t.Errorf("%s: %s@%s:%d, want %s@%s:%d", tt.alias, h.User, h.HostName, h.Port, tt.user, tt.hostName, tt.port)
+		}
+		if !reflect.DeepEqual(h.identityFiles, tt.identity) {
+			t.Errorf("%s: identity files %q, want %q", tt.alias, h.identityFiles, tt.identity)
+		}
+		options := make(map[string]string)
+		for _, o := range h.resolved {
+			if _, ok := options[o.key]; ok && o.key != "identityfile" {
+				t.Errorf("%s: %s resolved twice", tt.alias, o.name)
+			}
+			options[o.key] = o.value
+		}
+		for k, v := range tt.extra {
+			if options[k] != v {
+				t.Errorf("%s: %s = %q, want %q", tt.alias, k, options[k], v)
+			}
+		}
+	}
+
+	entries := make(map[string]*configAlias)
+	for _, a := range c.entries {
+		entries[a.name] = a
+	}
+	if len(c.entries) != 2 || c.entries[0].name != "web_prod" || c.entries[1].name != "db_prod" {
+		t.Fatalf("entries %v, want web_prod and db_prod", c.entries)
+	}
+	for _, tt := range tests[:2] {
+		a := entries[tt.alias]
+		tags, comment := parseComment(a.comment)
+		tags = mergeTags(a.tags, tags)
+		if comment != tt.comment || !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("%s: comment %q tags %v, want %q %v", tt.alias, comment, tags, tt.comment, tt.tags)
+		}
+	}
+}
+
+func TestHostLayerMerge(t *testing.T) {
+	dir := t.TempDir()
+	base := writeConfig(t, dir, "base", `
+Host db_prod # database id=7 env=prod
+    HostName 10.0.1.1
+    User postgres
+
+Host *
+    Port 22
+    User nobody
+`)
+	local := writeConfig(t, dir, "local", `
+Host db_prod # #primary
+    User admin
+
+Host *
+    Port 2222
+    ForwardAgent yes
+`)
+	c, err := loadSSHConfig(base, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := c.host("db_prod", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwardAgent := ""
+	for _, o := range h.resolved {
+		if o.key == "forwardagent" {
+			forwardAgent = o.value
+		}
+	}
+	if h.HostName != "10.0.1.1" || h.User != "admin" || h.Port != 2222 || forwardAgent != "yes" {
+		t.Errorf("db_prod: %s@%s:%d ForwardAgent %q, want admin@10.0.1.1:2222 ForwardAgent yes",
+			h.User, h.HostName, h.Port, forwardAgent)
+	}
+
+	if len(c.entries) != 1 {
+		t.Fatalf("%d entries, want 1", len(c.entries))
+	}
+	a := c.entries[0]
+	want := map[string]string{"id": "7", "env": "prod", "primary": ""}
+	if a.comment != "database" || !reflect.DeepEqual(a.tags, want) || a.file != local {
+		t.Errorf("db_prod: comment %q tags %v file %s, want %q %v %s", a.comment, a.tags, a.file, "database", want, local)
+	}
+}
+
+func TestHostMatchExec(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	path := writeConfig(t, dir, "config", `
+Match originalhost web exec "touch `+marker+`"
+    User fromexec
+
+Host web
+    User plain
+`)
+	c, err := loadSSHConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.matchExec {
+		t.Error("matchExec not set for a config with Match exec")
+	}
+
+	h, err := c.host("web", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err == nil || h.User != "plain" {
+		t.Errorf("without exec: user %q, command ran %v; want plain and no command", h.User, err == nil)
+	}
+
+	h, err = c.host("web", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil || h.User != "fromexec" {
+		t.Errorf("with exec: user %q, command ran %v; want fromexec and the command run", h.User, err == nil)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// input is the only reader of os.Stdin. The host list, prompts and sessions
+// each attach a consoleReader while they run; closing it hands the keys typed
+// afterwards to the next reader instead of to a goroutine that is still
+// blocked in a read.
+var input = newConsole(os.Stdin)
+
+type console struct {
+	r       io.Reader
+	mu      sync.Mutex
+	pending []byte
+	err     error
+	notify  chan struct{}
+	want    chan struct{}
+}
+
+type consoleReader struct {
+	c    *console
+	done chan struct{}
+	once sync.Once
+}
+
+func newConsole(r io.Reader) *console {
+	c := &console{r: r, notify: make(chan struct{}), want: make(chan struct{}, 1)}
+	go c.readLoop()
+	return c
+}
+
+// readLoop reads only while a reader waits for input, so nothing is taken
+// from the terminal while no one attached needs it.
+func (c *console) readLoop() {
+	buf := make([]byte, 1024)
+	for range c.want {
+		n, err := c.r.Read(buf)
+		c.mu.Lock()
+		c.pending = append(c.pending, buf[:n]...)
+		c.err = err
+		close(c.notify)
+		c.notify = make(chan struct{})
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *console) reader() *consoleReader {
+	return &consoleReader{c: c, done: make(chan struct{})}
+}
+
+// unread puts b back in front of the input not read yet.
+func (c *console) unread(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.pending = append(append([]byte{}, b...), c.pending...)
+	c.mu.Unlock()
+}
+
+func (r *consoleReader) Read(p []byte) (int, error) {
+	c := r.c
+	for {
+		select {
+		case <-r.done:
+			return 0, io.EOF
+		default:
+		}
+		c.mu.Lock()
+		if len(c.pending) > 0 {
+			n := copy(p, c.pending)
+			c.pending = c.pending[n:]
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+		notify := c.notify
+		c.mu.Unlock()
+
+		select {
+		case c.want <- struct{}{}:
+		default:
+		}
+		select {
+		case <-notify:
+		case <-r.done:
+			return 0, io.EOF
+		}
+	}
+}
+
+func (r *consoleReader) Close() error {
+	r.once.Do(func() { close(r.done) })
+	return nil
+}
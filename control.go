@@ -0,0 +1,461 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var errUnsafeControl = errors.New("unsafe control socket")
+
+// controlMaster is a connection shared with other jump processes on a control
+// socket. It lives while the session of its own process or an attached
+// process uses it, and then for ControlPersist.
+type controlMaster struct {
+	client   *ssh.Client
+	listener net.Listener
+	path     string
+	host     string
+	ask      bool
+	persist  time.Duration // below zero, until jump exits
+
+	mu    sync.Mutex
+	users int
+	timer *time.Timer
+	once  sync.Once
+	done  chan struct{}
+}
+
+var (
+	mastersMu sync.Mutex
+	masters   = make(map[*ssh.Client]*controlMaster)
+)
+
+func (h *Host) controlPath() string {
+	if h.ControlPath == "" || strings.ToLower(h.ControlPath) == "none" {
+		return ""
+	}
+	return h.expandPath(h.ControlPath)
+}
+
+func (h *Host) isControlMaster() bool {
+	switch strings.ToLower(h.ControlMaster) {
+	case "yes", "auto", "ask", "autoask":
+		return true
+	}
+	return false
+}
+
+// controlPersist parses ControlPersist: no closes the master once it is not
+// used, yes or 0 keeps it, and a time in seconds or with units keeps it that
+// long after its last use.
+func (h *Host) controlPersist() (time.Duration, error) {
+	switch strings.ToLower(h.ControlPersist) {
+	case "", "no":
+		return 0, nil
+	case "yes", "0":
+		return -1, nil
+	}
+	if seconds, err := strconv.Atoi(h.ControlPersist); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(h.ControlPersist)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("bad ControlPersist %q", h.ControlPersist)
+	}
+	return d, nil
+}
+
+// checkControlFile makes sure path is owned by the current user and not
+// accessible to anyone else, since that is what authenticates the master.
+func checkControlFile(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%w: %s is not owned by the current user", errUnsafeControl, path)
+	}
+	if fi.Mode().Perm() != 0600 {
+		return fmt.Errorf("%w: %s has mode %04o, want 0600", errUnsafeControl, path, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// dialControl attaches to the connection shared by another jump process on
+// the control socket. The master is authenticated by the host key it wrote
+// next to the socket.
+func dialControl(path, user string) (*ssh.Client, error) {
+	for _, p := range []string{path, path + ".pub"} {
+		if err := checkControlFile(p); err != nil {
+			return nil, err
+		}
+	}
+	data, err := ioutil.ReadFile(path + ".pub")
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s.pub: %v", path, err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, path, &ssh.ClientConfig{
+		User:            user,
+		HostKeyCallback: ssh.FixedHostKey(key),
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// serveControl shares upstream, the connection to h, with other jump
+// processes through a Unix socket at path. Every channel opened on the socket
+// is relayed to a channel of upstream. A socket that a live master still
+// listens on is left alone.
+func serveControl(path string, upstream *ssh.Client, h *Host) error {
+	persist, err := h.controlPersist()
+	if err != nil {
+		return err
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return err
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("control socket already in use")
+	}
+	_ = os.Remove(path)
+	_ = os.Remove(path + ".pub")
+	if err := ioutil.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0600); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		_ = os.Remove(path + ".pub")
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = l.Close()
+		_ = os.Remove(path + ".pub")
+		return err
+	}
+
+	mode := strings.ToLower(h.ControlMaster)
+	m := &controlMaster{
+		client:   upstream,
+		listener: l,
+		path:     path,
+		host:     h.Host,
+		ask:      mode == "ask" || mode == "autoask",
+		persist:  persist,
+		users:    1,
+		done:     make(chan struct{}),
+	}
+	mastersMu.Lock()
+	masters[upstream] = m
+	mastersMu.Unlock()
+
+	go func() {
+		_ = upstream.Wait()
+		m.close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go m.serveConn(conn, config)
+		}
+	}()
+	return nil
+}
+
+// releaseClient closes client once a session is done with it, unless it is a
+// control master, which then lives on while other processes use it and for
+// ControlPersist.
+func releaseClient(client *ssh.Client) {
+	mastersMu.Lock()
+	m := masters[client]
+	mastersMu.Unlock()
+	if m == nil {
+		_ = client.Close()
+		return
+	}
+	m.release()
+}
+
+// closeControlMasters closes the masters of this process before it exits.
+// They cannot outlive it, so ControlPersist no longer applies, but the
+// processes still attached are waited for.
+func closeControlMasters() {
+	mastersMu.Lock()
+	list := make([]*controlMaster, 0, len(masters))
+	for _, m := range masters {
+		list = append(list, m)
+	}
+	mastersMu.Unlock()
+
+	for _, m := range list {
+		m.mu.Lock()
+		m.persist = 0
+		users := m.users
+		m.mu.Unlock()
+		if users == 0 {
+			m.close()
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Waiting for the processes sharing the connection to %s\n", m.host)
+		<-m.done
+	}
+}
+
+func (m *controlMaster) acquire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users++
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+}
+
+func (m *controlMaster) release() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.users--; m.users > 0 {
+		return
+	}
+	switch {
+	case m.persist == 0:
+		go m.close()
+	case m.persist > 0:
+		m.timer = time.AfterFunc(m.persist, m.closeIdle)
+	}
+}
+
+func (m *controlMaster) closeIdle() {
+	m.mu.Lock()
+	idle := m.users == 0
+	m.mu.Unlock()
+	if idle {
+		m.close()
+	}
+}
+
+func (m *controlMaster) close() {
+	m.once.Do(func() {
+		_ = m.listener.Close()
+		_ = m.client.Close()
+		_ = os.Remove(m.path + ".pub")
+		mastersMu.Lock()
+		delete(masters, m.client)
+		mastersMu.Unlock()
+		close(m.done)
+	})
+}
+
+// askControl asks through $SSH_ASKPASS whether another process may use the
+// connection, as ControlMaster ask does. Without an askpass program the
+// process is refused.
+func (m *controlMaster) askControl() bool {
+	askpass := os.Getenv("SSH_ASKPASS")
+	if askpass == "" {
+		return false
+	}
+	cmd := exec.Command(askpass, fmt.Sprintf("Allow shared connection to %s? ", m.host))
+	cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+	return cmd.Run() == nil
+}
+
+func (m *controlMaster) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	if m.ask && !m.askControl() {
+		_ = conn.Close()
+		return
+	}
+	sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	m.acquire()
+	defer m.release()
+	defer sc.Close()
+	go m.relayGlobalRequests(sc, reqs)
+
+	for nc := range chans {
+		go relayChannel(nc, m.client)
+	}
+}
+
+// remoteForward is the payload of a tcpip-forward global request.
+type remoteForward struct {
+	Addr string
+	Port uint32
+}
+
+// forwardedTCP is the extra data of a forwarded-tcpip channel.
+type forwardedTCP struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// relayGlobalRequests passes the global requests of an attached process to
+// upstream. Remote forwards are opened on upstream by the master, which hands
+// every connection they receive to the process as a forwarded-tcpip channel,
+// and are closed with the process's connection.
+func (m *controlMaster) relayGlobalRequests(sc *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	forwards := make(map[string]net.Listener)
+	defer func() {
+		for _, l := range forwards {
+			_ = l.Close()
+		}
+	}()
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			fwd := &remoteForward{}
+			if err := ssh.Unmarshal(req.Payload, fwd); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			l, err := m.client.Listen("tcp", net.JoinHostPort(fwd.Addr, strconv.Itoa(int(fwd.Port))))
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			port := uint32(l.Addr().(*net.TCPAddr).Port)
+			forwards[net.JoinHostPort(fwd.Addr, strconv.Itoa(int(port)))] = l
+			go serveRemoteForward(sc, l, fwd.Addr, port)
+			_ = req.Reply(true, ssh.Marshal(&struct{ Port uint32 }{port}))
+		case "cancel-tcpip-forward":
+			fwd := &remoteForward{}
+			if err := ssh.Unmarshal(req.Payload, fwd); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			key := net.JoinHostPort(fwd.Addr, strconv.Itoa(int(fwd.Port)))
+			l, ok := forwards[key]
+			if ok {
+				_ = l.Close()
+				delete(forwards, key)
+			}
+			_ = req.Reply(ok, nil)
+		default:
+			ok, payload, err := m.client.SendRequest(req.Type, req.WantReply, req.Payload)
+			if req.WantReply {
+				_ = req.Reply(ok && err == nil, payload)
+			}
+		}
+	}
+}
+
+func serveRemoteForward(sc *ssh.ServerConn, l net.Listener, addr string, port uint32) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			origin, _ := conn.RemoteAddr().(*net.TCPAddr)
+			fwd := &forwardedTCP{Addr: addr, Port: port}
+			if origin != nil {
+				fwd.OriginAddr, fwd.OriginPort = origin.IP.String(), uint32(origin.Port)
+			}
+			ch, reqs, err := sc.OpenChannel("forwarded-tcpip", ssh.Marshal(fwd))
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			join(conn, ch)
+		}()
+	}
+}
+
+func relayChannel(nc ssh.NewChannel, upstream *ssh.Client) {
+	up, upReqs, err := upstream.OpenChannel(nc.ChannelType(), nc.ExtraData())
+	if err != nil {
+		if openErr, ok := err.(*ssh.OpenChannelError); ok {
+			_ = nc.Reject(openErr.Reason, openErr.Message)
+		} else {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	down, downReqs, err := nc.Accept()
+	if err != nil {
+		_ = up.Close()
+		return
+	}
+
+	go func() {
+		_, _ = io.Copy(up, down)
+		_ = up.CloseWrite()
+	}()
+	go func() {
+		relayRequests(downReqs, up)
+		_ = up.Close()
+	}()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(down, up)
+		_ = down.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(down.Stderr(), up.Stderr())
+	}()
+	go func() {
+		defer wg.Done()
+		relayRequests(upReqs, down)
+	}()
+	wg.Wait()
+	_ = down.Close()
+}
+
+func relayRequests(reqs <-chan *ssh.Request, ch ssh.Channel) {
+	for req := range reqs {
+		ok, err := ch.SendRequest(req.Type, req.WantReply, req.Payload)
+		if req.WantReply {
+			_ = req.Reply(ok && err == nil, nil)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestControlPersist(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"no", 0, false},
+		{"No", 0, false},
+		{"yes", -1, false},
+		{"0", -1, false},
+		{"600", 10 * time.Minute, false},
+		{"10m", 10 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"-5", 0, true},
+		{"0s", 0, true},
+		{"forever", 0, true},
+	}
+	for _, tt := range tests {
+		h := newHost("web")
+		h.ControlPersist = tt.value
+		got, err := h.controlPersist()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("controlPersist(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckControlFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		mode    os.FileMode
+		wantErr bool
+	}{
+		{0600, false},
+		{0644, true},
+		{0660, true},
+		{0400, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "control.pub")
+		if err := ioutil.WriteFile(path, []byte("key"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, tt.mode); err != nil {
+			t.Fatal(err)
+		}
+		err := checkControlFile(path)
+		if (err != nil) != tt.wantErr || err != nil && !errors.Is(err, errUnsafeControl) {
+			t.Errorf("checkControlFile(mode %04o) error %v, want unsafe %v", tt.mode, err, tt.wantErr)
+		}
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := checkControlFile(filepath.Join(dir, "missing"))
+	if err == nil || errors.Is(err, errUnsafeControl) {
+		t.Errorf("checkControlFile(missing) error %v, want a not exist error", err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	inventoryScriptTimeout = 30 * time.Second
+	refreshKey             = 0x12 // ctrl-r
+	interruptKey           = 0x03 // ctrl-c
+)
+
+var (
+	// inventoryTTL is how long the output of an inventory script is reused
+	// before the script is run again.
+	inventoryTTL = time.Hour
+	// refreshInventory makes the next load run every inventory script even if
+	// its cache is still fresh.
+	refreshInventory bool
+	// hasInventoryScript is set once a script is among the loaded sources.
+	hasInventoryScript bool
+	// warnings are shown above the host list until the next reload.
+	warnings []string
+)
+
+// isInventoryScript reports whether path is an executable, as opposed to a
+// config or inventory file: it has the executable bit and starts with #! or is
+// a binary, like Ansible's dynamic inventories.
+func isInventoryScript(path string) bool {
+	path = expandHome(path)
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".exe", ".bat", ".cmd":
+			return true
+		}
+		return false
+	}
+	if fi.Mode()&0111 == 0 {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+	return bytes.HasPrefix(magic, []byte("#!")) || bytes.Equal(magic, []byte("\x7fELF")) ||
+		bytes.Equal(magic, []byte{0xcf, 0xfa, 0xed, 0xfe})
+}
+
+// loadInventoryScript runs path with --list and reads the inventory JSON it
+// prints, in jump's or in Ansible's layout. The output is cached for
+// inventoryTTL; when the script fails the last good output is used and a
+// warning is shown.
+func loadInventoryScript(path string) (*sshConfig, error) {
+	hasInventoryScript = true
+	cache := inventoryCachePath(path)
+	if fi, err := os.Stat(cache); err == nil && !refreshInventory && time.Since(fi.ModTime()) < inventoryTTL {
+		if data, err := ioutil.ReadFile(cache); err == nil {
+			if inv, err := parseInventory(path, data); err == nil {
+				return inv.config(path)
+			}
+		}
+	}
+
+	data, err := runInventoryScript(path)
+	var inv *inventory
+	if err == nil {
+		inv, err = parseInventory(path, data)
+	}
+	if err == nil {
+		if err := writeFileAtomic(cache, data); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: caching output: %v", path, err))
+		}
+		return inv.config(path)
+	}
+
+	fi, cacheErr := os.Stat(cache)
+	if cacheErr != nil {
+		return nil, err
+	}
+	cached, cacheErr := ioutil.ReadFile(cache)
+	if cacheErr != nil {
+		return nil, err
+	}
+	inv, cacheErr = parseInventory(path, cached)
+	if cacheErr != nil {
+		return nil, err
+	}
+	warnings = append(warnings, fmt.Sprintf("%v; using the hosts cached %s", err, fi.ModTime().Format("2006-01-02 15:04:05")))
+	return inv.config(path)
+}
+
+func runInventoryScript(path string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), inventoryScriptTimeout)
+	defer cancel()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, expandHome(path), "--list")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", path, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func inventoryCachePath(path string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	abs, err := filepath.Abs(expandHome(path))
+	if err != nil {
+		abs = path
+	}
+	return filepath.Join(dir, "jump", fmt.Sprintf("inventory-%x.json", sha1.Sum([]byte(abs))))
+}
+
+// writeFileAtomic replaces path with data, so that readers never see it half
+// written.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// refreshReader passes the terminal input to the host list and turns the
+// refresh key into an interrupt, so the list returns and can be reloaded.
+// The keys typed after it are left in input for the reloaded list.
+type refreshReader struct {
+	*consoleReader
+	refresh bool
+}
+
+func (r *refreshReader) Read(p []byte) (int, error) {
+	if r.refresh {
+		<-r.done
+		return 0, io.EOF
+	}
+	n, err := r.consoleReader.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == refreshKey {
+			r.c.unread(p[i+1 : n])
+			p[i] = interruptKey
+			r.refresh = true
+			return i + 1, err
+		}
+	}
+	return n, err
+}
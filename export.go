@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hostRecord is the resolved view of a Host that jump hands to other tools.
+type hostRecord struct {
+	ID            int               `json:"id"`
+	Alias         string            `json:"alias"`
+	Env           string            `json:"env"`
+	Groups        []string          `json:"groups,omitempty"`
+	User          string            `json:"user"`
+	HostName      string            `json:"hostname"`
+	Port          int               `json:"port"`
+	Comment       string            `json:"comment,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+	Fields        map[string]string `json:"fields,omitempty"`
+	IdentityFiles []string          `json:"identity_files,omitempty"`
+	ProxyJump     string            `json:"proxy_jump,omitempty"`
+	ProxyCommand  string            `json:"proxy_command,omitempty"`
+	Source        string            `json:"source"`
+}
+
+func (h *Host) record() *hostRecord {
+	return &hostRecord{
+		ID:            h.Index,
+		Alias:         h.Host,
+		Env:           h.Env,
+		Groups:        h.groups,
+		User:          h.User,
+		HostName:      h.HostName,
+		Port:          h.Port,
+		Comment:       h.Comment,
+		Tags:          h.Tags,
+		Fields:        h.Fields,
+		IdentityFiles: h.identityFiles,
+		ProxyJump:     h.ProxyJump,
+		ProxyCommand:  h.ProxyCommand,
+		Source:        h.Source,
+	}
+}
+
+// exportGroups is where h belongs in a grouped export: its inventory groups,
+// else its Env unless that is the default one.
+func (h *Host) exportGroups() []string {
+	if len(h.groups) > 0 {
+		return h.groups
+	}
+	if h.Env != "" && h.Env != "default" {
+		return []string{h.Env}
+	}
+	return nil
+}
+
+// sshOptions returns the options resolved for h from its config, in the
+// order they were set, after its HostName, User and Port.
+func (h *Host) sshOptions() []configOption {
+	options := make([]configOption, 0, len(h.resolved)+3)
+	for _, o := range []configOption{
+		{key: "hostname", name: "HostName", value: h.HostName},
+		{key: "user", name: "User", value: h.User},
+		{key: "port", name: "Port", value: strconv.Itoa(h.Port)},
+	} {
+		if o.value != "" {
+			options = append(options, o)
+		}
+	}
+	for _, o := range h.resolved {
+		switch o.key {
+		case "hostname", "user", "port":
+			continue
+		}
+		options = append(options, o.quoted())
+	}
+	return options
+}
+
+// quoted returns o with its value quoted when it is a path that ssh_config
+// would otherwise split at its spaces.
+func (o configOption) quoted() configOption {
+	switch o.key {
+	case "identityfile", "certificatefile", "dynamicforward":
+		if strings.ContainsAny(o.value, " \t") {
+			o.value = strconv.Quote(o.value)
+		}
+	}
+	return o
+}
+
+// runExport prints the resolved hosts of paths in the requested format and
+// returns the exit status.
+func runExport(paths []string, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "json", "output `format`: json, csv, ansible or ssh_config")
+	tags := make(stringsFlag, 0)
+	fs.Var(&tags, "tag", "only export hosts tagged `key=value` or key, may be repeated")
+	terms, err := parseQueryArgs(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	for _, tag := range tags {
+		terms = append(terms, "tag:"+strconv.Quote(tag))
+	}
+	query, err := parseQuery(joinQuery(terms))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		return exitUsage
+	}
+
+	var write func(io.Writer, []*Host) error
+	switch *format {
+	case "json":
+		write = exportJSON
+	case "csv":
+		write = exportCSV
+	case "ansible":
+		write = exportAnsible
+	case "ssh_config":
+		write = exportSSHConfig
+	default:
+		fmt.Fprintf(os.Stderr, "jump: unknown export format %q\n", *format)
+		return exitUsage
+	}
+
+	if err := loadHosts(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		return exitError
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	if err := write(os.Stdout, query.rank(hosts)); err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func exportJSON(w io.Writer, list []*Host) error {
+	records := make([]*hostRecord, 0, len(list))
+	for _, h := range list {
+		records = append(records, h.record())
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func exportCSV(w io.Writer, list []*Host) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "alias", "env", "groups", "user", "hostname", "port", "comment", "tags", "identity_files", "proxy_jump", "source"})
+	for _, h := range list {
+		_ = cw.Write([]string{
+			strconv.Itoa(h.Index), h.Host, h.Env, strings.Join(h.groups, "/"), h.User, h.HostName, strconv.Itoa(h.Port),
+			h.Comment, formatTags(h.Tags), strings.Join(h.identityFiles, ";"), h.ProxyJump, h.Source,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportAnsible writes an INI inventory. Each host is listed in its innermost
+// group and outer groups name the inner ones as children.
+func exportAnsible(w io.Writer, list []*Host) error {
+	order := make([]string, 0)
+	members := make(map[string][]*Host)
+	children := make(map[string][]string)
+	seen := make(map[string]bool)
+	ungrouped := make([]*Host, 0)
+	for _, h := range list {
+		groups := h.exportGroups()
+		if len(groups) == 0 {
+			ungrouped = append(ungrouped, h)
+			continue
+		}
+		for i, g := range groups {
+			if !seen[g] {
+				seen[g] = true
+				order = append(order, g)
+			}
+			if i > 0 && !containsString(children[groups[i-1]], g) {
+				children[groups[i-1]] = append(children[groups[i-1]], g)
+			}
+		}
+		inner := groups[len(groups)-1]
+		members[inner] = append(members[inner], h)
+	}
+
+	for _, h := range ungrouped {
+		if _, err := fmt.Fprintln(w, ansibleHostLine(h)); err != nil {
+			return err
+		}
+	}
+	for _, g := range order {
+		if len(members[g]) > 0 {
+			fmt.Fprintf(w, "\n[%s]\n", g)
+			for _, h := range members[g] {
+				fmt.Fprintln(w, ansibleHostLine(h))
+			}
+		}
+		if len(children[g]) > 0 {
+			fmt.Fprintf(w, "\n[%s:children]\n", g)
+			for _, c := range children[g] {
+				fmt.Fprintln(w, c)
+			}
+		}
+	}
+	return nil
+}
+
+func ansibleHostLine(h *Host) string {
+	fields := []string{h.Host, "ansible_host=" + h.HostName, "ansible_user=" + h.User, "ansible_port=" + strconv.Itoa(h.Port)}
+	if len(h.identityFiles) > 0 {
+		fields = append(fields, "ansible_ssh_private_key_file="+shellQuote(h.identityFiles[0]))
+	}
+	if h.ProxyJump != "" && strings.ToLower(h.ProxyJump) != "none" {
+		fields = append(fields, "ansible_ssh_common_args="+shellQuote("-o ProxyJump="+h.ProxyJump))
+	} else if h.ProxyCommand != "" && strings.ToLower(h.ProxyCommand) != "none" {
+		fields = append(fields, "ansible_ssh_common_args="+shellQuote("-o ProxyCommand="+strconv.Quote(h.ProxyCommand)))
+	}
+	return strings.Join(fields, " ")
+}
+
+// exportSSHConfig writes one Host block per host with every resolved option,
+// so the output works without the files it was built from.
+func exportSSHConfig(w io.Writer, list []*Host) error {
+	for i, h := range list {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		alias := h.Host
+		if strings.ContainsAny(alias, " \t") {
+			alias = strconv.Quote(alias)
+		}
+		comment := strings.TrimSpace(h.Comment + " " + formatTags(h.Tags))
+		fmt.Fprintf(w, "Host %s # %s\n", alias, strings.TrimSpace(comment+" id="+strconv.Itoa(h.Index)))
+		for _, o := range h.sshOptions() {
+			if _, err := fmt.Fprintf(w, "    %s %s\n", o.name, o.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if tags[k] == "" {
+			parts = append(parts, "#"+k)
+		} else {
+			parts = append(parts, k+"="+tags[k])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t'\"\\$") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	socks5Version             = 0x05
+	socks5Connect             = 0x01
+	socks5IPv4                = 0x01
+	socks5Domain              = 0x03
+	socks5IPv6                = 0x04
+	socks5Succeeded           = 0x00
+	socks5Failure             = 0x01
+	socks5CommandNotSupported = 0x07
+	socks5AddressNotSupported = 0x08
+	socks5NoAuth              = 0x00
+	socks5NoAcceptable        = 0xff
+)
+
+// forward opens the LocalForward, RemoteForward and DynamicForward tunnels of
+// the host on the session's client. They live until the session ends; a
+// tunnel that cannot be set up is reported and skipped.
+func (s *Session) forward() {
+	gatewayPorts := isYes(s.hostConfig.GatewayPorts)
+
+	for _, spec := range s.hostConfig.localForwards {
+		bind, target, err := parseForward(spec, gatewayPorts)
+		if err == nil && target == "" {
+			err = fmt.Errorf("missing target")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: LocalForward %s: %v\r\n", spec, err)
+			continue
+		}
+		l, err := net.Listen("tcp", bind)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: LocalForward %s: %v\r\n", spec, err)
+			continue
+		}
+		go s.serveForward(l, func(conn net.Conn) {
+			remote, err := s.client.Dial("tcp", target)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "LocalForward %s: %v\r\n", target, err)
+				_ = conn.Close()
+				return
+			}
+			join(conn, remote)
+		})
+	}
+
+	for _, spec := range s.hostConfig.remoteForwards {
+		bind, target, err := parseForward(spec, false)
+		if err == nil && target == "" {
+			err = fmt.Errorf("missing target")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: RemoteForward %s: %v\r\n", spec, err)
+			continue
+		}
+		if host, port, _ := net.SplitHostPort(bind); host == "" {
+			bind = net.JoinHostPort("0.0.0.0", port)
+		} else if host == "localhost" {
+			bind = net.JoinHostPort("127.0.0.1", port)
+		}
+		l, err := s.client.Listen("tcp", bind)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: RemoteForward %s: %v\r\n", spec, err)
+			continue
+		}
+		go s.serveForward(l, func(conn net.Conn) {
+			local, err := net.Dial("tcp", target)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "RemoteForward %s: %v\r\n", target, err)
+				_ = conn.Close()
+				return
+			}
+			join(conn, local)
+		})
+	}
+
+	for _, spec := range s.hostConfig.dynamicForwards {
+		bind, _, err := parseForward(spec, gatewayPorts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: DynamicForward %s: %v\r\n", spec, err)
+			continue
+		}
+		l, err := net.Listen("tcp", bind)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: DynamicForward %s: %v\r\n", spec, err)
+			continue
+		}
+		go s.serveForward(l, s.socks5)
+	}
+}
+
+func (s *Session) serveForward(l net.Listener, handle func(net.Conn)) {
+	go func() {
+		<-s.ctx.Done()
+		_ = l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go handle(conn)
+	}
+}
+
+// parseForward splits "[bind_address:]port [host:hostport]". Without a bind
+// address the listener only accepts loopback connections unless gatewayPorts
+// is set; "*" binds every interface.
+func parseForward(spec string, gatewayPorts bool) (string, string, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", "", fmt.Errorf("bad forwarding specification")
+	}
+
+	bind, port := "localhost", fields[0]
+	if gatewayPorts {
+		bind = ""
+	}
+	if host, p, err := net.SplitHostPort(fields[0]); err == nil {
+		bind, port = host, p
+		if bind == "*" {
+			bind = ""
+		}
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", fmt.Errorf("bad port %q", port)
+	}
+
+	target := ""
+	if len(fields) == 2 {
+		if _, _, err := net.SplitHostPort(fields[1]); err != nil {
+			return "", "", err
+		}
+		target = fields[1]
+	}
+	return net.JoinHostPort(bind, port), target, nil
+}
+
+// socks5 serves a SOCKS5 CONNECT request by dialing the destination through
+// the session's client. Only the no-authentication method is offered.
+func (s *Session) socks5(conn net.Conn) {
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != socks5Version {
+		_ = conn.Close()
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		_ = conn.Close()
+		return
+	}
+	if bytes.IndexByte(buf[:buf[1]], socks5NoAuth) < 0 {
+		_, _ = conn.Write([]byte{socks5Version, socks5NoAcceptable})
+		_ = conn.Close()
+		return
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5NoAuth}); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		_ = conn.Close()
+		return
+	}
+	if buf[1] != socks5Connect {
+		socks5Reply(conn, socks5CommandNotSupported)
+		return
+	}
+
+	var host string
+	switch buf[3] {
+	case socks5IPv4, socks5IPv6:
+		size := net.IPv4len
+		if buf[3] == socks5IPv6 {
+			size = net.IPv6len
+		}
+		if _, err := io.ReadFull(conn, buf[:size]); err != nil {
+			_ = conn.Close()
+			return
+		}
+		host = net.IP(buf[:size]).String()
+	case socks5Domain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			_ = conn.Close()
+			return
+		}
+		size := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:size]); err != nil {
+			_ = conn.Close()
+			return
+		}
+		host = string(buf[:size])
+	default:
+		socks5Reply(conn, socks5AddressNotSupported)
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		_ = conn.Close()
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+
+	remote, err := s.client.Dial("tcp", addr)
+	if err != nil {
+		socks5Reply(conn, socks5Failure)
+		return
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5Succeeded, 0, socks5IPv4, 0, 0, 0, 0, 0, 0}); err != nil {
+		_ = conn.Close()
+		_ = remote.Close()
+		return
+	}
+	join(conn, remote)
+}
+
+func socks5Reply(conn net.Conn, code byte) {
+	_, _ = conn.Write([]byte{socks5Version, code, 0, socks5IPv4, 0, 0, 0, 0, 0, 0})
+	_ = conn.Close()
+}
+
+func join(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	_ = a.Close()
+	_ = b.Close()
+}
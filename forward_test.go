@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		spec         string
+		gatewayPorts bool
+		listen       string
+		target       string
+		wantErr      bool
+	}{
+		{"8080 localhost:80", false, "localhost:8080", "localhost:80", false},
+		{"8080 localhost:80", true, ":8080", "localhost:80", false},
+		{"127.0.0.1:8080 db:5432", true, "127.0.0.1:8080", "db:5432", false},
+		{"*:8080 db:5432", false, ":8080", "db:5432", false},
+		{"[::1]:8080 [fe80::1]:22", false, "[::1]:8080", "[fe80::1]:22", false},
+		{"1080", false, "localhost:1080", "", false},
+		{"0.0.0.0:1080", false, "0.0.0.0:1080", "", false},
+		{"", false, "", "", true},
+		{"8080 db:5432 extra", false, "", "", true},
+		{"http localhost:80", false, "", "", true},
+		{"70000 localhost:80", false, "", "", true},
+		{"8080 localhost", false, "", "", true},
+	}
+	for _, tt := range tests {
+		listen, target, err := parseForward(tt.spec, tt.gatewayPorts)
+		if (err != nil) != tt.wantErr || listen != tt.listen || target != tt.target {
+			t.Errorf("parseForward(%q, %v) = %q, %q, %v, want %q, %q, error %v",
+				tt.spec, tt.gatewayPorts, listen, target, err, tt.listen, tt.target, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Scores of the fuzzy matcher, after fzf: every matched character scores,
+// more so at the start of a word or right after the previous match, and
+// skipped characters cost a little.
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+	bonusBoundary     = 8
+	bonusCamel        = 7
+	bonusConsecutive  = 4
+	bonusFirstChar    = 2
+	scoreIndex        = 1000
+	noScore           = math.MinInt32 / 2
+)
+
+const (
+	markStart = "\x1b[1;4m"
+	markEnd   = "\x1b[22;24m"
+)
+
+type charClass int
+
+const (
+	classSpace charClass = iota
+	classPunct
+	classLower
+	classUpper
+	classDigit
+	classCJK
+	classOther
+)
+
+func classOf(r rune) charClass {
+	switch {
+	case unicode.IsSpace(r):
+		return classSpace
+	case unicode.IsPunct(r) || unicode.IsSymbol(r):
+		return classPunct
+	case unicode.IsLower(r):
+		return classLower
+	case unicode.IsUpper(r):
+		return classUpper
+	case unicode.IsDigit(r):
+		return classDigit
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		return classCJK
+	}
+	return classOther
+}
+
+// bonusAt rewards a match at the start of a word: after a separator, at a
+// lower to upper case or letter to digit change, or where CJK text starts.
+func bonusAt(prev, cur charClass) int {
+	switch {
+	case cur == classSpace || cur == classPunct:
+		return 0
+	case prev == classSpace || prev == classPunct:
+		return bonusBoundary
+	case prev == classLower && cur == classUpper, prev != classDigit && cur == classDigit:
+		return bonusCamel
+	case prev != classCJK && cur == classCJK:
+		return bonusBoundary
+	}
+	return 0
+}
+
+// fuzzyMatch finds pattern, already lower case, as a subsequence of text,
+// ignoring case. It returns the best score and the rune positions matched.
+func fuzzyMatch(pattern []rune, text string) (int, []int, bool) {
+	if len(pattern) == 0 {
+		return 0, nil, true
+	}
+	t := []rune(text)
+	n, m := len(t), len(pattern)
+	lower := make([]rune, n)
+	for j, r := range t {
+		lower[j] = unicode.ToLower(r)
+	}
+	for i, j := 0, 0; i < m; j++ {
+		if j == n {
+			return 0, nil, false
+		}
+		if lower[j] == pattern[i] {
+			i++
+		}
+	}
+
+	bonus := make([]int, n)
+	prev := classSpace
+	for j, r := range t {
+		c := classOf(r)
+		bonus[j] = bonusAt(prev, c)
+		prev = c
+	}
+
+	// chunk is the bonus of the first character of the consecutive run a
+	// match ends, which the rest of the run gets too.
+	score := make([][]int, m)
+	from := make([][]int, m)
+	chunk := make([][]int, m)
+	for i := range pattern {
+		score[i] = make([]int, n)
+		from[i] = make([]int, n)
+		chunk[i] = make([]int, n)
+		gap, gapAt := noScore, -1
+		for j := 0; j < n; j++ {
+			if i > 0 && j >= 2 {
+				if gap != noScore {
+					gap += scoreGapExtension
+				}
+				if s := score[i-1][j-2]; s != noScore && s+scoreGapStart > gap {
+					gap, gapAt = s+scoreGapStart, j-2
+				}
+			}
+			score[i][j], from[i][j] = noScore, -1
+			if lower[j] != pattern[i] {
+				continue
+			}
+			if i == 0 {
+				score[i][j], chunk[i][j] = scoreMatch+bonus[j]*bonusFirstChar, bonus[j]
+				continue
+			}
+			if j >= 1 && score[i-1][j-1] != noScore {
+				b := chunk[i-1][j-1]
+				if bonus[j] > b {
+					b = bonus[j]
+				}
+				chunk[i][j] = b
+				if b < bonusConsecutive {
+					b = bonusConsecutive
+				}
+				score[i][j], from[i][j] = score[i-1][j-1]+scoreMatch+b, j-1
+			}
+			if gap != noScore && gap+scoreMatch+bonus[j] > score[i][j] {
+				score[i][j], from[i][j], chunk[i][j] = gap+scoreMatch+bonus[j], gapAt, bonus[j]
+			}
+		}
+	}
+
+	best, end := noScore, -1
+	for j, s := range score[m-1] {
+		if s > best {
+			best, end = s, j
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+	positions := make([]int, m)
+	for i, j := m-1, end; i >= 0; i-- {
+		positions[i] = j
+		j = from[i][j]
+	}
+	return best, positions, true
+}
+
+// searchFields are the texts of h that a query is matched against, by the
+// name Highlight knows them by. Those shown in the list come first so that
+// they get the marks when a word scores the same elsewhere.
+func (h *Host) searchFields() [][2]string {
+	return [][2]string{
+		{"Env", h.Env},
+		{"User", h.User},
+		{"HostName", h.HostName},
+		{"Comment", h.Comment},
+		{"Columns", h.Columns()},
+		{"Host", h.Host},
+		{"groups", strings.Join(h.groups, " ")},
+		{"tags", formatTags(h.Tags)},
+	}
+}
+
+// fuzzyScore matches word against the fields of h and returns the best score
+// with the field and the rune positions it matched.
+func (h *Host) fuzzyScore(word string) (int, string, []int, bool) {
+	if number, err := strconv.Atoi(word); err == nil && number == h.Index {
+		return scoreIndex, "", nil, true
+	}
+	pattern := []rune(strings.ToLower(word))
+	best, bestField, bestPositions := noScore, "", []int(nil)
+	for _, field := range h.searchFields() {
+		if s, positions, ok := fuzzyMatch(pattern, field[1]); ok && s > best {
+			best, bestField, bestPositions = s, field[0], positions
+		}
+	}
+	return best, bestField, bestPositions, best != noScore
+}
+
+// Highlight returns the named field of h with the characters matched by the
+// last search marked, for the host list templates.
+func (h *Host) Highlight(field string) string {
+	value := ""
+	for _, f := range h.searchFields() {
+		if f[0] == field {
+			value = f[1]
+		}
+	}
+	positions := h.marks[field]
+	if len(positions) == 0 {
+		return value
+	}
+	marked := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		marked[p] = true
+	}
+
+	var b strings.Builder
+	in := false
+	for i, r := range []rune(value) {
+		if marked[i] != in {
+			in = marked[i]
+			if in {
+				b.WriteString(markStart)
+			} else {
+				b.WriteString(markEnd)
+			}
+		}
+		b.WriteRune(r)
+	}
+	if in {
+		b.WriteString(markEnd)
+	}
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		text      string
+		ok        bool
+		positions []int
+	}{
+		{"", "anything", true, nil},
+		{"web", "web_prod", true, []int{0, 1, 2}},
+		{"web", "WEB_PROD", true, []int{0, 1, 2}},
+		{"wp", "web_prod", true, []int{0, 4}},
+		{"prod", "web_prod", true, []int{4, 5, 6, 7}},
+		{"db", "web_prod", false, nil},
+		{"prodd", "web_prod", false, nil},
+		{"pr", "approve pr", true, []int{8, 9}},
+		{"gw", "GatewayWest", true, []int{0, 7}},
+		{"10", "host10", true, []int{4, 5}},
+		{"机器", "测试机器列表", true, []int{2, 3}},
+	}
+	for _, tt := range tests {
+		_, positions, ok := fuzzyMatch([]rune(tt.pattern), tt.text)
+		if ok != tt.ok || !reflect.DeepEqual(positions, tt.positions) {
+			t.Errorf("fuzzyMatch(%q, %q) = %v, %v, want %v, %v", tt.pattern, tt.text, positions, ok, tt.positions, tt.ok)
+		}
+	}
+}
+
+func TestFuzzyMatchRanking(t *testing.T) {
+	tests := []struct {
+		pattern string
+		better  string
+		worse   string
+	}{
+		{"db", "db01", "dumb01"},
+		{"db", "prod_db", "prodb"},
+		{"web", "a_web01", "wxexbx"},
+		{"api", "api-gw", "a_p_i"},
+		{"ng", "nginx", "staging"},
+		{"gw", "GatewayWest", "gowest"},
+	}
+	for _, tt := range tests {
+		better, _, ok1 := fuzzyMatch([]rune(tt.pattern), tt.better)
+		worse, _, ok2 := fuzzyMatch([]rune(tt.pattern), tt.worse)
+		if !ok1 || !ok2 || better <= worse {
+			t.Errorf("fuzzyMatch(%q): %q scores %d, %q scores %d, want the first higher",
+				tt.pattern, tt.better, better, tt.worse, worse)
+		}
+	}
+}
+
+func TestFuzzyScoreIndex(t *testing.T) {
+	h := newHost("web_prod")
+	h.Index, h.Env = 42, "prod"
+	if score, _, _, ok := h.fuzzyScore("42"); !ok || score != scoreIndex {
+		t.Errorf("fuzzyScore(42) = %d, %v, want %d", score, ok, scoreIndex)
+	}
+	if score, field, _, ok := h.fuzzyScore("prod"); !ok || score >= scoreIndex || field != "Env" {
+		t.Errorf("fuzzyScore(prod) = %d, %q, %v, want a match in Env", score, field, ok)
+	}
+}
@@ -4,8 +4,8 @@ go 1.16
 
 require (
 	github.com/gogf/gf v1.15.6
-	github.com/kevinburke/ssh_config v1.1.0
 	github.com/manifoldco/promptui v0.8.0
 	github.com/sjatsh/go-scp v1.1.4
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
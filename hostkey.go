@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	strictYes       = "yes"
+	strictNo        = "no"
+	strictOff       = "off"
+	strictAcceptNew = "accept-new"
+	strictAsk       = "ask"
+)
+
+var (
+	globalKnownHostsFiles = []string{"/etc/ssh/ssh_known_hosts", "/etc/ssh/ssh_known_hosts2"}
+	errHostKeyVerify      = errors.New("host key verification failed")
+)
+
+// userKnownHostsFiles returns the files of UserKnownHostsFile; "none" names
+// no file at all.
+func (h *Host) userKnownHostsFiles() []string {
+	files := make([]string, 0)
+	for _, f := range strings.Fields(h.UserKnownHostsFile) {
+		if !strings.EqualFold(f, "none") {
+			files = append(files, h.expandPath(f))
+		}
+	}
+	return files
+}
+
+func (h *Host) globalKnownHostsFiles() []string {
+	if h.GlobalKnownHostsFile == "" {
+		return globalKnownHostsFiles
+	}
+	files := make([]string, 0)
+	for _, f := range strings.Fields(h.GlobalKnownHostsFile) {
+		if !strings.EqualFold(f, "none") {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// hostKeyCallback checks server keys against the known_hosts files and also
+// returns the key algorithms already recorded for addr, so the handshake does
+// not negotiate a key type we have never seen and report it as a mismatch.
+func (h *Host) hostKeyCallback(addr string) (ssh.HostKeyCallback, []string, error) {
+	files := make([]string, 0)
+	for _, f := range append(h.userKnownHostsFiles(), h.globalKnownHostsFiles()...) {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+
+	db := func(string, net.Addr, ssh.PublicKey) error {
+		return &knownhosts.KeyError{}
+	}
+	if len(files) > 0 {
+		var err error
+		if db, err = knownhosts.New(files...); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := db(hostname, remote, key)
+		switch e := err.(type) {
+		case nil:
+			return nil
+		case *knownhosts.RevokedError:
+			fmt.Fprintf(os.Stderr, "%s host key for %s was revoked in %s:%d\r\n", key.Type(), hostname, e.Revoked.Filename, e.Revoked.Line)
+			return errHostKeyVerify
+		case *knownhosts.KeyError:
+			// knownhosts lists @cert-authority keys among the wanted ones,
+			// but a CA does not make a plain host key known.
+			want := make([]knownhosts.KnownKey, 0, len(e.Want))
+			for _, k := range e.Want {
+				if !isCertAuthority(k) {
+					want = append(want, k)
+				}
+			}
+			if len(want) == 0 {
+				return h.unknownHostKey(hostname, remote, key)
+			}
+			return h.changedHostKey(hostname, key, want)
+		default:
+			return err
+		}
+	}
+	return callback, knownHostKeyAlgorithms(db, addr), nil
+}
+
+func knownHostKeyAlgorithms(db ssh.HostKeyCallback, addr string) []string {
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(db(addr, &net.TCPAddr{IP: net.IPv4zero}, probe), &keyErr) || len(keyErr.Want) == 0 {
+		return nil
+	}
+	// A host vouched for by a CA is asked for a certificate first, and may
+	// still fall back to a plain key, which is then treated as unknown.
+	certs := make([]string, 0, len(keyErr.Want))
+	algorithms := make([]string, 0, len(keyErr.Want))
+	for _, k := range keyErr.Want {
+		if isCertAuthority(k) {
+			certs = append(certs, k.Key.Type()+"-cert-v01@openssh.com")
+		}
+		if !containsString(algorithms, k.Key.Type()) {
+			algorithms = append(algorithms, k.Key.Type())
+		}
+	}
+	return append(certs, algorithms...)
+}
+
+// isCertAuthority tells whether k comes from a @cert-authority line, which
+// knownhosts does not record.
+func isCertAuthority(k knownhosts.KnownKey) bool {
+	f, err := os.Open(k.Filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if n == k.Line {
+			return strings.HasPrefix(strings.TrimSpace(scanner.Text()), "@cert-authority")
+		}
+	}
+	return false
+}
+
+func (h *Host) unknownHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	switch strings.ToLower(h.StrictHostKeyChecking) {
+	case strictYes:
+		fmt.Fprintf(os.Stderr, "No %s host key is known for %s and you have requested strict checking.\r\n", key.Type(), hostname)
+		return errHostKeyVerify
+	case strictNo, strictOff, strictAcceptNew:
+	default:
+		fmt.Fprintf(os.Stderr, "The authenticity of host '%s (%s)' can't be established.\r\n", hostname, remote)
+		fmt.Fprintf(os.Stderr, "%s key fingerprint is %s.\r\n", key.Type(), fingerprint)
+		answer, err := readLine("Are you sure you want to continue connecting (yes/no/[fingerprint])? ")
+		for {
+			if err != nil {
+				return err
+			}
+			if answer == "yes" || answer == fingerprint {
+				break
+			}
+			if answer == "no" {
+				return errHostKeyVerify
+			}
+			answer, err = readLine("Please type 'yes', 'no' or the fingerprint: ")
+		}
+	}
+	return h.addKnownHost(hostname, key)
+}
+
+func (h *Host) addKnownHost(hostname string, key ssh.PublicKey) error {
+	files := h.userKnownHostsFiles()
+	if len(files) == 0 {
+		return nil
+	}
+	file := files[0]
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: Permanently added '%s' (%s) to the list of known hosts.\r\n", hostname, key.Type())
+	return nil
+}
+
+func (h *Host) changedHostKey(hostname string, key ssh.PublicKey, want []knownhosts.KnownKey) error {
+	fmt.Fprint(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\r\n")
+	fmt.Fprint(os.Stderr, "@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\r\n")
+	fmt.Fprint(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\r\n")
+	fmt.Fprint(os.Stderr, "IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!\r\n")
+	fmt.Fprint(os.Stderr, "Someone could be eavesdropping on you right now (man-in-the-middle attack)!\r\n")
+	fmt.Fprint(os.Stderr, "It is also possible that a host key has just been changed.\r\n")
+	fmt.Fprintf(os.Stderr, "The fingerprint for the %s key sent by the remote host %s is\r\n%s.\r\n", key.Type(), hostname, ssh.FingerprintSHA256(key))
+	for _, k := range want {
+		fmt.Fprintf(os.Stderr, "Known %s key %s in %s:%d\r\n", k.Key.Type(), ssh.FingerprintSHA256(k.Key), k.Filename, k.Line)
+	}
+
+	switch strings.ToLower(h.StrictHostKeyChecking) {
+	case strictNo, strictOff:
+		fmt.Fprint(os.Stderr, "StrictHostKeyChecking is disabled, connecting anyway.\r\n")
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Host key for %s has changed and you have requested strict checking.\r\n", hostname)
+	return errHostKeyVerify
+}
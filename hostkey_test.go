@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func testHostKey(t *testing.T) (ssh.PublicKey, ssh.Signer) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer.PublicKey(), signer
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	known, _ := testHostKey(t)
+	hashed, _ := testHostKey(t)
+	revoked, _ := testHostKey(t)
+	other, _ := testHostKey(t)
+	ca, caSigner := testHostKey(t)
+
+	cert := &ssh.Certificate{
+		Key:             other,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"web.ca.example.com"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	_, rogueSigner := testHostKey(t)
+	rogue := &ssh.Certificate{
+		Key:             other,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"web.ca.example.com"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := rogue.SignCert(rand.Reader, rogueSigner); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Join([]string{
+		knownhosts.Line([]string{"known.example.com"}, known),
+		knownhosts.Line([]string{knownhosts.HashHostname("hashed.example.com")}, hashed),
+		"@revoked " + knownhosts.Line([]string{"*"}, revoked),
+		"@cert-authority " + knownhosts.Line([]string{"*.ca.example.com"}, ca),
+	}, "\n") + "\n"
+
+	tests := []struct {
+		name       string
+		host       string
+		key        ssh.PublicKey
+		strict     string
+		wantErr    bool
+		added      bool
+		algorithms []string
+	}{
+		{"matching key", "known.example.com", known, strictYes, false, false, []string{ssh.KeyAlgoED25519}},
+		{"hashed entry", "hashed.example.com", hashed, strictYes, false, false, []string{ssh.KeyAlgoED25519}},
+		{"changed key", "known.example.com", other, strictYes, true, false, []string{ssh.KeyAlgoED25519}},
+		{"changed key, accept-new", "known.example.com", other, strictAcceptNew, true, false, []string{ssh.KeyAlgoED25519}},
+		{"changed key, checking off", "known.example.com", other, strictNo, false, false, []string{ssh.KeyAlgoED25519}},
+		{"changed hashed key", "hashed.example.com", other, strictYes, true, false, []string{ssh.KeyAlgoED25519}},
+		{"revoked key", "known.example.com", revoked, strictNo, true, false, []string{ssh.KeyAlgoED25519}},
+		{"unknown host, strict", "new.example.com", other, strictYes, true, false, nil},
+		{"unknown host, accept-new", "new.example.com", other, strictAcceptNew, false, true, nil},
+		{"unknown host, checking off", "new.example.com", other, strictNo, false, true, nil},
+		{"certificate from a known CA", "web.ca.example.com", cert, strictYes, false, false,
+			[]string{ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519}},
+		{"certificate from another CA", "web.ca.example.com", rogue, strictNo, true, false,
+			[]string{ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519}},
+		{"plain key of a CA host, strict", "web.ca.example.com", other, strictYes, true, false,
+			[]string{ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519}},
+		{"plain key of a CA host, accept-new", "web.ca.example.com", other, strictAcceptNew, false, true,
+			[]string{ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "known_hosts")
+			if err := ioutil.WriteFile(path, []byte(lines), 0600); err != nil {
+				t.Fatal(err)
+			}
+			h := newHost(tt.host)
+			h.UserKnownHostsFile = path
+			h.GlobalKnownHostsFile = "none"
+			h.StrictHostKeyChecking = tt.strict
+
+			addr := net.JoinHostPort(tt.host, "22")
+			callback, algorithms, err := h.hostKeyCallback(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(algorithms, tt.algorithms) {
+				t.Errorf("algorithms %q, want %q", algorithms, tt.algorithms)
+			}
+			err = callback(addr, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("callback error %v, want error %v", err, tt.wantErr)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			added := strings.TrimPrefix(string(data), lines)
+			if want := knownhosts.Line([]string{tt.host}, tt.key) + "\n"; tt.added && added != want {
+				t.Errorf("known_hosts gained %q, want %q", added, want)
+			} else if !tt.added && added != "" {
+				t.Errorf("known_hosts gained %q, want nothing", added)
+			}
+		})
+	}
+}
+
+func TestKnownHostsFilesNone(t *testing.T) {
+	dir := t.TempDir()
+	h := newHost("new.example.com")
+	h.UserKnownHostsFile = "none"
+	h.GlobalKnownHostsFile = "NONE"
+	h.StrictHostKeyChecking = strictAcceptNew
+	if files := append(h.userKnownHostsFiles(), h.globalKnownHostsFiles()...); len(files) != 0 {
+		t.Errorf("known hosts files %q, want none", files)
+	}
+
+	key, _ := testHostKey(t)
+	callback, _, err := h.hostKeyCallback("new.example.com:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback("new.example.com:22", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}, key); err != nil {
+		t.Errorf("callback error %v, want nil", err)
+	}
+	if matches, _ := filepath.Glob("none"); len(matches) != 0 {
+		t.Error("a file named none was created")
+	}
+
+	h.UserKnownHostsFile = filepath.Join(dir, "a") + " none " + filepath.Join(dir, "b")
+	if files := h.userKnownHostsFiles(); !reflect.DeepEqual(files, []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}) {
+		t.Errorf("known hosts files %q, want a and b", files)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogf/gf/encoding/gyaml"
+)
+
+const importUsage = "usage: jump import [--format yaml|ssh_config] ansible|xshell|securecrt|mobaxterm <file>"
+
+// runImport converts another tool's host list into a jump inventory, written
+// to stdout as YAML or as ssh_config, and returns the exit status. Settings
+// that have no equivalent in jump are reported on stderr.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "yaml", "output `format`: yaml or ssh_config")
+	args, err := parseQueryArgs(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, importUsage)
+		return exitUsage
+	}
+	if *format != "yaml" && *format != "ssh_config" {
+		fmt.Fprintf(os.Stderr, "jump: unknown import format %q\n%s\n", *format, importUsage)
+		return exitUsage
+	}
+	source, path := args[0], args[1]
+
+	var inv *inventory
+	var report *importReport
+	switch source {
+	case "ansible":
+		var data []byte
+		if data, err = ioutil.ReadFile(expandHome(path)); err == nil {
+			inv, err = decodeAnsible(path, data)
+		}
+	case "xshell":
+		inv, report, err = importXshell(path)
+	case "securecrt":
+		inv, report, err = importSecureCRT(path)
+	case "mobaxterm":
+		inv, report, err = importMobaXterm(path)
+	default:
+		fmt.Fprintf(os.Stderr, "jump: unknown import source %q\n%s\n", source, importUsage)
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		return exitError
+	}
+
+	if *format == "ssh_config" {
+		err = importSSHConfig(os.Stdout, path, inv)
+	} else {
+		var out []byte
+		if out, err = gyaml.Encode(inv); err == nil {
+			_, err = os.Stdout.Write(out)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		return exitError
+	}
+
+	if report != nil {
+		notes := report.notes
+		if *format == "yaml" {
+			notes = append(report.renamed, notes...)
+		}
+		for _, note := range notes {
+			fmt.Fprintf(os.Stderr, "jump: %s\n", note)
+		}
+		if report.skipped > 0 {
+			fmt.Fprintf(os.Stderr, "jump: %d sessions skipped\n", report.skipped)
+		}
+	}
+	return exitOK
+}
+
+// decodeAnsible reads an Ansible inventory in any of its formats; inventories
+// are often named without an extension, so YAML is recognized by content.
+func decodeAnsible(path string, data []byte) (*inventory, error) {
+	switch {
+	case strings.ToLower(filepath.Ext(path)) == ".json":
+		return parseAnsibleJSON(path, data)
+	case isAnsibleYAML(data):
+		return parseAnsibleYAML(path, data)
+	}
+	return parseAnsibleINI(path, data)
+}
+
+// importSSHConfig writes inv as one Host block per host. The alias is built
+// from the folders of the session the way jump reads aliases back: the inner
+// folders, the session name and the top folder as its env, joined by "_".
+func importSSHConfig(w io.Writer, path string, inv *inventory) error {
+	c, err := inv.config(path)
+	if err != nil {
+		return err
+	}
+	sessions := make(map[string]string)
+	var walk func(hosts []*inventoryHost, groups []*inventoryGroup)
+	walk = func(hosts []*inventoryHost, groups []*inventoryGroup) {
+		for _, h := range hosts {
+			if h.session != "" {
+				sessions[h.Name] = h.session
+			}
+		}
+		for _, g := range groups {
+			walk(g.Hosts, g.Children)
+		}
+	}
+	walk(inv.Hosts, inv.Groups)
+
+	seen := make(map[string]bool)
+	for i, a := range c.aliases() {
+		h, err := c.host(a.name, false)
+		if err != nil {
+			return err
+		}
+		name := a.name
+		if sessions[name] != "" {
+			name = sessions[name]
+		}
+		alias := importAlias(name, a.groups)
+		for n := 2; seen[alias]; n++ {
+			alias = importAlias(fmt.Sprintf("%s-%d", name, n), a.groups)
+		}
+		seen[alias] = true
+
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if comment := strings.TrimSpace(a.comment + " " + formatTags(a.tags)); comment != "" {
+			fmt.Fprintf(w, "Host %s # %s\n", alias, comment)
+		} else {
+			fmt.Fprintf(w, "Host %s\n", alias)
+		}
+		// The alias no longer names the host, so it needs a HostName.
+		options := append([]configOption{{key: "hostname", name: "HostName", value: a.name}}, h.resolved...)
+		for _, o := range h.resolved {
+			if o.key == "hostname" {
+				options = h.resolved
+				break
+			}
+		}
+		for _, o := range options {
+			o = o.quoted()
+			if _, err := fmt.Fprintf(w, "    %s %s\n", o.name, o.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func importAlias(name string, folders []string) string {
+	parts := []string{name}
+	if len(folders) > 0 {
+		parts = append(append(append([]string{}, folders[1:]...), name), folders[0])
+	}
+	for i, p := range parts {
+		parts[i] = strings.ReplaceAll(strings.Join(strings.Fields(p), "-"), "_", "-")
+	}
+	return strings.Join(parts, "_")
+}
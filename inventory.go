@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gogf/gf/encoding/gtoml"
+	"github.com/gogf/gf/encoding/gyaml"
+)
+
+// inventory is jump's own host list format, written as YAML, TOML or JSON:
+//
+//	defaults:
+//	  user: root
+//	groups:
+//	  - name: prod
+//	    bastion: bastion_prod
+//	    tags: {region: sh}
+//	    hosts:
+//	      - {name: web01, hostname: 10.0.0.1, comment: nginx}
+//	    children:
+//	      - name: db
+//	        user: dba
+//	        hosts:
+//	          - {name: db01, hostname: 10.0.1.1, tags: [primary]}
+//
+// Groups pass their options down to children and hosts, the innermost value
+// winning. The top-level group of a host becomes its Env.
+type inventory struct {
+	Defaults inventoryOptions  `json:"defaults" yaml:"defaults,omitempty"`
+	Groups   []*inventoryGroup `json:"groups" yaml:"groups,omitempty"`
+	Hosts    []*inventoryHost  `json:"hosts" yaml:"hosts,omitempty"`
+}
+
+type inventoryOptions struct {
+	User          string                 `json:"user" yaml:"user,omitempty"`
+	Port          int                    `json:"port" yaml:"port,omitempty"`
+	IdentityFile  string                 `json:"identity_file" yaml:"identity_file,omitempty"`
+	IdentityFiles []string               `json:"identity_files" yaml:"identity_files,omitempty"`
+	Bastion       string                 `json:"bastion" yaml:"bastion,omitempty"`
+	Options       map[string]interface{} `json:"options" yaml:"options,omitempty"`
+	Tags          inventoryTags          `json:"tags" yaml:"tags,omitempty"`
+}
+
+type inventoryGroup struct {
+	Name             string `json:"name" yaml:"name"`
+	inventoryOptions `yaml:",inline"`
+	Hosts            []*inventoryHost  `json:"hosts" yaml:"hosts,omitempty"`
+	Children         []*inventoryGroup `json:"children" yaml:"children,omitempty"`
+}
+
+type inventoryHost struct {
+	Name             string `json:"name" yaml:"name"`
+	HostName         string `json:"hostname" yaml:"hostname,omitempty"`
+	Comment          string `json:"comment" yaml:"comment,omitempty"`
+	inventoryOptions `yaml:",inline"`
+
+	session string // name of the imported session, before it was adjusted
+}
+
+// inventoryTags accepts either a key/value map or a list of bare tags.
+type inventoryTags map[string]string
+
+func (t *inventoryTags) UnmarshalJSON(data []byte) error {
+	tags := make(map[string]interface{})
+	if err := json.Unmarshal(data, &tags); err != nil {
+		list := make([]string, 0)
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("tags must be a map or a list")
+		}
+		for _, tag := range list {
+			tags[tag] = ""
+		}
+	}
+	*t = make(inventoryTags, len(tags))
+	for k, v := range tags {
+		(*t)[k] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+func isInventoryFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".toml", ".json", ".ini":
+		return true
+	}
+	return false
+}
+
+// loadInventory turns an inventory file into Host blocks, so its hosts are
+// resolved, layered and listed exactly like ssh_config entries. Ansible
+// inventories are recognized by their layout and read as well.
+func loadInventory(path string) (*sshConfig, error) {
+	data, err := ioutil.ReadFile(expandHome(path))
+	if err != nil {
+		return nil, err
+	}
+	inv, err := decodeInventory(path, data)
+	if err != nil {
+		return nil, err
+	}
+	return inv.config(path)
+}
+
+func decodeInventory(path string, data []byte) (*inventory, error) {
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ini":
+		return parseAnsibleINI(path, data)
+	case ".yaml", ".yml":
+		if isAnsibleYAML(data) {
+			return parseAnsibleYAML(path, data)
+		}
+		data, err = gyaml.ToJson(data)
+	case ".toml":
+		data, err = gtoml.ToJson(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return parseInventory(path, data)
+}
+
+// parseInventory reads an inventory in its JSON form, either jump's own or
+// the output of an Ansible dynamic inventory.
+func parseInventory(path string, data []byte) (*inventory, error) {
+	top := make(map[string]interface{})
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if isAnsibleShape(top) {
+		return parseAnsibleJSON(path, data)
+	}
+
+	inv := &inventory{}
+	if err := json.Unmarshal(data, inv); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return inv, nil
+}
+
+func (inv *inventory) config(path string) (*sshConfig, error) {
+	c := &sshConfig{}
+	root := &inventoryGroup{inventoryOptions: inv.Defaults, Hosts: inv.Hosts, Children: inv.Groups}
+	if err := c.addInventoryGroup(path, root, nil, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *sshConfig) addInventoryGroup(path string, g *inventoryGroup, groups []string, parents []*inventoryOptions) error {
+	if g.Name != "" {
+		groups = append(append([]string{}, groups...), g.Name)
+	}
+	parents = append([]*inventoryOptions{&g.inventoryOptions}, parents...)
+
+	for _, h := range g.Hosts {
+		if h.Name == "" {
+			return fmt.Errorf("%s: host without name in group %q", path, strings.Join(groups, "/"))
+		}
+		options := make([]configOption, 0)
+		if h.HostName != "" {
+			options = append(options, configOption{key: "hostname", name: "HostName", value: h.HostName})
+		}
+		tags := make(map[string]string)
+		for _, o := range append([]*inventoryOptions{&h.inventoryOptions}, parents...) {
+			options = append(options, o.configOptions()...)
+		}
+		for i := len(parents) - 1; i >= 0; i-- {
+			for k, v := range parents[i].Tags {
+				tags[k] = v
+			}
+		}
+		for k, v := range h.Tags {
+			tags[k] = v
+		}
+
+		c.blocks = append(c.blocks, &configBlock{
+			kind:    "host",
+			args:    []string{h.Name},
+			comment: h.Comment,
+			file:    path,
+			options: options,
+			groups:  groups,
+			tags:    tags,
+		})
+	}
+
+	for _, child := range g.Children {
+		if err := c.addInventoryGroup(path, child, groups, parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *inventoryOptions) configOptions() []configOption {
+	options := make([]configOption, 0)
+	if o.User != "" {
+		options = append(options, configOption{key: "user", name: "User", value: o.User})
+	}
+	if o.Port != 0 {
+		options = append(options, configOption{key: "port", name: "Port", value: strconv.Itoa(o.Port)})
+	}
+	if o.IdentityFile != "" {
+		options = append(options, configOption{key: "identityfile", name: "IdentityFile", value: o.IdentityFile})
+	}
+	for _, f := range o.IdentityFiles {
+		options = append(options, configOption{key: "identityfile", name: "IdentityFile", value: f})
+	}
+	if o.Bastion != "" {
+		options = append(options, configOption{key: "proxyjump", name: "ProxyJump", value: o.Bastion})
+	}
+
+	keys := make([]string, 0, len(o.Options))
+	for k := range o.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		options = append(options, configOption{key: strings.ToLower(k), name: k, value: fmt.Sprint(o.Options[k])})
+	}
+	return options
+}
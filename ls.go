@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// runList prints the hosts of paths that fit the query in args, one per line
+// or as JSON, and returns the exit status.
+func runList(paths []string, args []string) int {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the hosts as JSON, as jump export does")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: jump ls [query] [--json]")
+		fs.PrintDefaults()
+	}
+	words, err := parseQueryArgs(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	query, err := parseQuery(joinQuery(words))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		return exitUsage
+	}
+
+	if err := loadHosts(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		return exitError
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	list := query.rank(hosts)
+	if *asJSON {
+		if err := exportJSON(os.Stdout, list); err != nil {
+			fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+			return exitError
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, h := range list {
+			comment := strings.TrimSpace(h.Comment + " " + formatTags(h.Tags))
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s@%s:%d\t%s\n", h.Index, h.Host, h.Env, h.User, h.HostName, h.Port, comment)
+		}
+		if err := tw.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+			return exitError
+		}
+	}
+	if len(list) == 0 {
+		return exitNoMatch
+	}
+	return exitOK
+}
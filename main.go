@@ -3,22 +3,22 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/gogf/gf/util/gconv"
-	"github.com/kevinburke/ssh_config"
 	"github.com/manifoldco/promptui"
 	"github.com/sjatsh/go-scp"
 	"golang.org/x/crypto/ssh"
@@ -27,6 +27,14 @@ import (
 
 type Host struct {
 	hosts                           []string
+	groups                          []string
+	marks                           map[string][]int
+	identityFiles                   []string
+	certificateFiles                []string
+	localForwards                   []string
+	remoteForwards                  []string
+	dynamicForwards                 []string
+	resolved                        []configOption
 	Index                           int
 	Env                             string
 	Host                            string
@@ -42,17 +50,27 @@ type Host struct {
 	ControlPath                     string
 	ControlPersist                  string
 	GatewayPorts                    string
+	GlobalKnownHostsFile            string
 	HostName                        string
 	IdentitiesOnly                  string
-	IdentityFile                    string
+	IdentityAgent                   string
+	KbdInteractiveAuthentication    string
 	LocalCommand                    string
-	LocalForward                    string
+	NumberOfPasswordPrompts         int
 	PasswordAuthentication          string
 	PermitLocalCommand              string
 	Port                            int
+	PreferredAuthentications        string
 	ProxyCommand                    string
+	ProxyJump                       string
+	PubkeyAuthentication            string
+	StrictHostKeyChecking           string
 	User                            string
+	UserKnownHostsFile              string
 	Comment                         string
+	Source                          string
+	Tags                            map[string]string
+	Fields                          map[string]string
 }
 
 type Session struct {
@@ -66,6 +84,10 @@ type Session struct {
 	writeLock   *sync.RWMutex
 	stdinPiper  io.WriteCloser
 	stdoutPiper io.Reader
+
+	// transfers are the down and up commands still running on client.
+	transfers sync.WaitGroup
+	pending   int32
 }
 
 type cmdEntity struct {
@@ -74,12 +96,38 @@ type cmdEntity struct {
 	hasUpDown bool
 }
 
+// hostRow is how a host is shown in the host list.
+const hostRow = `{{ if .Favorite }}{{ "★" | yellow }} {{ end }}{{ .Index | cyan }}: {{ .Highlight "Env" | cyan }} {{ if .Columns }}{{ .Highlight "Columns" | magenta }} {{ end }}{{ .Highlight "User" | green }} {{ .Highlight "HostName" | yellow }} {{ .Highlight "Comment" | white }}{{ with .Chips }} {{ . }}{{ end }}`
+
+// Exit statuses of jump. A session opened from the command line exits with
+// the status of the remote shell instead.
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitNoMatch   = 3
+	exitAmbiguous = 4
+)
+
+const usage = `usage: jump [flags] [query]
+       jump ls [query] [--json]
+       jump show <alias|id|query>
+       jump export [--format json|csv|ansible|ssh_config] [--tag key=value]... [query]
+       jump import [--format yaml|ssh_config] ansible|xshell|securecrt|mobaxterm <file>
+
+A query that matches a single host connects to it, otherwise the host list
+opens with the query in its search.
+
+`
+
 const (
 	bash        = "-bash: %s: "
 	cmdNotFound = "command not found"
 )
 
 var (
+	sshCfg         *sshConfig
+	hosts          []*Host
 	clear          map[string]func()
 	allCmd         = []string{"down", "up"}
 	allCmdNotFound []string
@@ -110,138 +158,278 @@ func init() {
 }
 
 func main() {
-	f, err := os.Open(filepath.Join(os.Getenv("HOME"), ".ssh", "config"))
-	if err != nil {
-		panic(err)
+	configFiles := make(stringsFlag, 0)
+	flag.Var(&configFiles, "F", "ssh config, .yaml/.toml/.json/.ini inventory or inventory script `file`, may be repeated to layer files (default $JUMP_CONFIG or ~/.ssh/config)")
+	flag.DurationVar(&inventoryTTL, "inventory-ttl", inventoryTTL, "how long to cache the output of inventory scripts, ctrl-r in the host list refreshes it")
+	pattern := flag.String("alias-pattern", os.Getenv("JUMP_ALIAS_PATTERN"), "`regexp` whose named groups (env, service, region...) are read from host aliases, instead of taking the env after the last _")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *pattern != "" {
+		var err error
+		if aliasPattern, err = compileAliasPattern(*pattern); err != nil {
+			fmt.Fprintf(os.Stderr, "jump: -alias-pattern: %v\n", err)
+			os.Exit(exitUsage)
+		}
 	}
-	sshCfg, err := ssh_config.Decode(f)
-	if err != nil {
-		panic(err)
+	if flag.Arg(0) == "import" {
+		os.Exit(runImport(flag.Args()[1:]))
+	}
+	paths := configPaths(configFiles)
+	switch flag.Arg(0) {
+	case "export":
+		os.Exit(runExport(paths, flag.Args()[1:]))
+	case "ls":
+		os.Exit(runList(paths, flag.Args()[1:]))
+	case "show":
+		os.Exit(runShow(paths, flag.Args()[1:]))
 	}
 
-	idx := 0
-	hosts := make([]*Host, 0)
-	for _, h := range sshCfg.Hosts {
-		if h.Patterns[0].String() == "*" {
-			continue
-		}
-		idx++
-		host := &Host{
-			Env:          "default",
-			Index:        idx,
-			Host:         h.Patterns[0].String(),
-			User:         os.Getenv("USER"),
-			Port:         22,
-			IdentityFile: filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa"),
-			Comment:      h.EOLComment,
-		}
+	if err := loadHosts(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		os.Exit(exitError)
+	}
 
-		params := make(map[string]string)
-		for _, node := range h.Nodes {
-			switch v := node.(type) {
-			case *ssh_config.KV:
-				params[v.Key] = v.Value
-			}
-		}
-		if err := gconv.Struct(params, host); err != nil {
-			panic(err)
+	query := joinQuery(flag.Args())
+	if query != "" {
+		list, err := findHosts(hosts, query)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+			os.Exit(exitUsage)
 		}
-
-		hostSlice := strings.Split(host.Host, "_")
-		host.hosts = hostSlice
-		if len(hostSlice) > 1 {
-			host.Env = hostSlice[len(hostSlice)-1]
+		if len(list) == 1 {
+			os.Exit(connectDirect(list[0]))
 		}
-		host.IdentityFile = strings.ReplaceAll(host.IdentityFile, "~", os.Getenv("HOME"))
-		hosts = append(hosts, host)
 	}
 
 	templates := &promptui.SelectTemplates{
 		Label:    "{{ . }}:",
-		Active:   "\U0001F449 {{ .Index | cyan }}: {{ .Env | cyan }} {{ .User | green }} {{ .HostName | yellow }} {{ .Comment | white }}",
-		Inactive: "  {{ .Index | cyan }}: {{ .Env | cyan }} {{ .User | green }} {{ .HostName | yellow }} {{ .Comment | white }}",
-		Selected: "\U0001F449 {{ .Index | cyan }}: {{ .Env | cyan }} {{ .User | green }} {{ .HostName | yellow }} {{ .Comment | white }}",
+		Active:   "\U0001F449 {{ with .Node }}{{ .Name | cyan }} ({{ .Count }}){{ end }}{{ with .Host }}" + hostRow + "{{ end }}",
+		Inactive: "  {{ with .Node }}{{ .Name | cyan }} ({{ .Count }}){{ end }}{{ with .Host }}" + hostRow + "{{ end }}",
+		Selected: "\U0001F449 {{ with .Node }}{{ .Name | cyan }}{{ end }}{{ with .Host }}" + hostRow + "{{ end }}",
+		Details:  "{{ with .Host }}{{ .Highlight \"Host\" | cyan }} {{ .User | green }}@{{ .HostName | yellow }}:{{ .Port }} {{ .Source | faint }}{{ end }}",
 	}
 
-	searcher := func(input string, index int) bool {
-		host := hosts[index]
-		number, errNumber := strconv.Atoi(input)
-		if errNumber == nil && number == host.Index {
-			return true
-		}
-		if strings.Contains(host.Env, input) {
-			return true
+	m := newMenu(hosts, templates)
+	if query != "" {
+		m.search = true
+		input.unread([]byte(query))
+	}
+	for {
+		host, err := m.run()
+		if err == errRefresh {
+			refreshInventory = true
+			if err := loadHosts(paths); err != nil {
+				warnings = append(warnings, err.Error())
+			}
+			refreshInventory = false
+			m = newMenu(hosts, templates)
+			continue
 		}
-		if strings.Contains(host.User, input) {
-			return true
+		if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+			closeControlMasters()
+			return
 		}
-		if strings.Contains(host.HostName, input) {
-			return true
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+			closeControlMasters()
+			os.Exit(exitError)
 		}
-		if strings.Contains(host.Comment, input) {
-			return true
+		if _, err := exitStatus(connectServer(host)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", host.Host, err)
+			pause()
+			continue
 		}
-		for _, h := range host.hosts {
-			if strings.Contains(h, input) {
-				return true
-			}
+		state.connected(host.Host)
+		if err := state.save(); err != nil {
+			warnings = append(warnings, err.Error())
 		}
-		return false
+		m.updateSections()
+	}
+}
+
+// connectDirect opens a session to host without the host list and returns
+// the exit status of its remote shell.
+func connectDirect(host *Host) int {
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	status, err := exitStatus(connectServer(host))
+	closeControlMasters()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", host.Host, err)
+		return exitError
+	}
+	state.connected(host.Host)
+	if err := state.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 	}
+	return status
+}
 
-	prompt := promptui.Select{
-		Size:              20,
-		Label:             "机器列表",
-		Items:             hosts,
-		Templates:         templates,
-		Searcher:          searcher,
-		StartInSearchMode: true,
+// exitStatus tells the end of a session apart from a failed connection: the
+// exit status of the remote shell is returned without an error.
+func exitStatus(err error) (int, error) {
+	switch e := err.(type) {
+	case nil:
+		return exitOK, nil
+	case *ssh.ExitError:
+		return e.ExitStatus(), nil
+	case *ssh.ExitMissingError:
+		return exitError, nil
+	}
+	return exitError, err
+}
+
+// loadHosts reads the host list from the config sources at paths. On error
+// the hosts loaded before are kept.
+func loadHosts(paths []string) error {
+	warnings = nil
+	if err := loadState(); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+	cfg, err := loadSSHConfig(paths...)
+	if err != nil {
+		return err
 	}
 
-	for {
-		clear[runtime.GOOS]()
-		idx, _, err := prompt.Run()
+	list := make([]*Host, 0)
+	for _, alias := range cfg.entries {
+		host, err := cfg.host(alias.name, false)
 		if err != nil {
-			if err == promptui.ErrInterrupt {
-				return
+			return fmt.Errorf("%s: %v", alias.name, err)
+		}
+		host.Source = alias.file
+		tags, comment := parseComment(alias.comment)
+		host.Tags = mergeTags(alias.tags, tags)
+		host.Comment = comment
+
+		if len(alias.groups) > 0 {
+			host.groups = alias.groups
+			host.Env = alias.groups[0]
+			host.hosts = append(append([]string{}, alias.groups...), host.Host)
+		} else if aliasPattern == nil {
+			hostSlice := strings.Split(host.Host, "_")
+			host.hosts = hostSlice
+			if len(hostSlice) > 1 {
+				host.Env = hostSlice[len(hostSlice)-1]
 			}
-			panic(err)
+		} else {
+			host.hosts = []string{host.Host}
 		}
-		host := hosts[idx]
-		if err := connectServer(host); err != nil {
-			panic(err)
+
+		host.Fields = aliasFields(host.Host)
+		for name, value := range host.Tags {
+			if value == "" || !isFieldName(name) {
+				continue
+			}
+			if host.Fields == nil {
+				host.Fields = make(map[string]string)
+			}
+			host.Fields[name] = value
+		}
+		if env := host.Fields["env"]; env != "" {
+			host.Env = env
 		}
+		list = append(list, host)
 	}
+	if err := assignIDs(list); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+	sshCfg, hosts = cfg, list
+	return nil
+}
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func newHost(alias string) *Host {
+	return &Host{
+		Env:      "default",
+		Host:     alias,
+		HostName: alias,
+		User:     os.Getenv("USER"),
+		Port:     22,
+
+		StrictHostKeyChecking: strictAsk,
+		UserKnownHostsFile:    filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"),
+	}
+}
+
+// expandPath expands a leading ~ and the %C, %L, %l, %d, %h, %n, %p, %r and %u
+// tokens allowed in ssh_config file names and commands.
+func (h *Host) expandPath(path string) string {
+	home := os.Getenv("HOME")
+	if strings.HasPrefix(path, "~") {
+		path = home + path[1:]
+	}
+	local, _ := os.Hostname()
+	return strings.NewReplacer(
+		"%%", "%",
+		"%C", fmt.Sprintf("%x", sha1.Sum([]byte(local+h.HostName+strconv.Itoa(h.Port)+h.User))),
+		"%L", strings.Split(local, ".")[0],
+		"%l", local,
+		"%d", home,
+		"%h", h.HostName,
+		"%n", h.Host,
+		"%p", strconv.Itoa(h.Port),
+		"%r", h.User,
+		"%u", os.Getenv("USER"),
+	).Replace(path)
 }
 
 func (h *Host) getClient() (*ssh.Client, error) {
-	fileData, err := ioutil.ReadFile(h.IdentityFile)
-	if err != nil {
-		return nil, err
+	controlPath := h.controlPath()
+	if controlPath != "" {
+		client, err := dialControl(controlPath, h.User)
+		if err == nil {
+			return client, nil
+		}
+		if errors.Is(err, errUnsafeControl) {
+			fmt.Fprintf(os.Stderr, "Warning: ControlPath %s: %v\r\n", controlPath, err)
+		}
 	}
-	singer, err := ssh.ParsePrivateKey(fileData)
+
+	chain, err := h.jumpChain(0)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", h.HostName, h.Port), &ssh.ClientConfig{
-		User:            h.User,
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(singer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
-	})
-	if err != nil {
-		return nil, err
+	var client *ssh.Client
+	for _, hop := range chain {
+		if client, err = hop.connect(client); err != nil {
+			return nil, err
+		}
+	}
+
+	if controlPath != "" && h.isControlMaster() {
+		if err := serveControl(controlPath, client, h); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ControlPath %s: %v\r\n", controlPath, err)
+		}
 	}
 	return client, nil
 }
 
 func connectServer(host *Host) error {
+	host, err := host.withExec()
+	if err != nil {
+		return err
+	}
 	client, err := host.getClient()
 	if err != nil {
 		return err
 	}
-	defer client.Close()
+	defer releaseClient(client)
 
 	session, err := client.NewSession()
 	if err != nil {
@@ -303,6 +491,7 @@ func connectServer(host *Host) error {
 		stdinPiper:  stdinPiper,
 		stdoutPiper: stdoutPiper,
 	}
+	s.forward()
 	go s.watchWinch()
 	go s.ping()
 
@@ -312,8 +501,18 @@ func connectServer(host *Host) error {
 	if err = session.Shell(); err != nil {
 		return err
 	}
-	_ = session.Wait()
-	return nil
+	err = session.Wait()
+	s.waitTransfers()
+	return err
+}
+
+// waitTransfers keeps the client open until the file transfers started from
+// the session are done, as closing it would cut them off.
+func (s *Session) waitTransfers() {
+	if n := atomic.LoadInt32(&s.pending); n > 0 {
+		fmt.Fprintf(os.Stderr, "\r\nWaiting for %d file transfers to finish\r\n", n)
+	}
+	s.transfers.Wait()
 }
 
 func (s *Session) watchWinch() error {
@@ -361,14 +560,18 @@ func (s *Session) ping() {
 }
 
 func (s *Session) writePiperStdin() error {
-	fd := int(os.Stdin.Fd())
+	in := input.reader()
+	go func() {
+		<-s.ctx.Done()
+		_ = in.Close()
+	}()
 	buf := make([]byte, 128)
 	for {
 		select {
 		case <-s.ctx.Done():
 			return nil
 		default:
-			n, err := syscall.Read(fd, buf)
+			n, err := in.Read(buf)
 			if err != nil {
 				return err
 			}
@@ -464,11 +667,7 @@ func (s *Session) runCmd(cmdStr string) error {
 			return nil
 		}
 
-		client, err := s.hostConfig.getClient()
-		if err != nil {
-			return err
-		}
-		scpClient := scp.NewSCP(client)
+		scpClient := scp.NewSCP(s.client)
 
 		fileName := filepath.Base(strings.TrimSpace(cmdParams[1]))
 		localPath := "."
@@ -477,22 +676,24 @@ func (s *Session) runCmd(cmdStr string) error {
 		}
 		localPath = filepath.Join(localPath, fileName)
 
+		s.transfers.Add(1)
+		atomic.AddInt32(&s.pending, 1)
 		go func() {
-			defer client.Close()
-
+			defer s.transfers.Done()
+			defer atomic.AddInt32(&s.pending, -1)
 			switch cmd {
 			case "down":
 				if err := scpClient.ReceiveFile(cmdParams[1], localPath); err != nil && err != io.EOF {
-					_ = s.sendMsg(fmt.Sprintf("\r\rdown %s error: %v   ", fileName, err))
+					s.report(fmt.Sprintf("down %s error: %v", fileName, err))
 					return
 				}
-				_ = s.sendMsg(fmt.Sprintf("\r\rdown %s success   ", fileName))
+				s.report(fmt.Sprintf("down %s success", fileName))
 			case "up":
 				if err := scpClient.SendFile(cmdParams[1], localPath); err != nil && err != io.EOF {
-					_ = s.sendMsg(fmt.Sprintf("\r\rup %s error: %v   ", fileName, err))
+					s.report(fmt.Sprintf("up %s error: %v", fileName, err))
 					return
 				}
-				_ = s.sendMsg(fmt.Sprintf("\r\rup %s success   ", fileName))
+				s.report(fmt.Sprintf("up %s success", fileName))
 			}
 		}()
 
@@ -502,6 +703,14 @@ func (s *Session) runCmd(cmdStr string) error {
 	return nil
 }
 
+// report shows the outcome of a transfer in the session, or on stderr once
+// the remote shell is gone.
+func (s *Session) report(msg string) {
+	if err := s.sendMsg("\r\r" + msg + "   "); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\r\n", msg)
+	}
+}
+
 func (s *Session) sendMsg(msg string) error {
 	s.writeLock.Lock()
 	defer s.writeLock.Unlock()
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/manifoldco/promptui"
+	"github.com/manifoldco/promptui/list"
+)
+
+const (
+	menuSize       = 20
+	menuLabel      = "机器列表"
+	favoritesLabel = "★ favorites"
+	recentLabel    = "recent"
+	favoriteKey    = 0x13 // ctrl-s
+	enterKey       = '\r'
+	backspaceKey   = 0x7f
+	ctrlHKey       = 0x08
+	ctrlUKey       = 0x15
+	escapeKey      = 0x1b
+	// noSearchKey replaces / as the key that leaves search mode, so that /
+	// can be typed in a query.
+	noSearchKey = 0x1c
+)
+
+type menuAction int
+
+const (
+	actionNone menuAction = iota
+	actionUp
+	actionSearch
+	actionFavorite
+)
+
+var errRefresh = errors.New("refresh requested")
+
+// menuNode is an env or a group of the host tree, with the number of hosts
+// below it. The favorites and recent sections are nodes of the root too.
+type menuNode struct {
+	Name     string
+	Count    int
+	path     []string
+	children []*menuNode
+	hosts    []*Host
+	section  bool
+}
+
+// menuItem is a row of the host list: a node to open or a host to connect to.
+type menuItem struct {
+	Node *menuNode
+	Host *Host
+}
+
+type menuLevel struct {
+	node           *menuNode
+	cursor, scroll int
+}
+
+// menu browses the hosts env by env and group by group. Typing anywhere
+// switches to a search over every host; backspace goes back up.
+type menu struct {
+	root      *menuNode
+	favorites *menuNode
+	recent    *menuNode
+	stack     []*menuLevel
+	search    bool
+	templates *promptui.SelectTemplates
+}
+
+// hostPath is where h sits in the tree: its inventory groups, or else its Env
+// followed by the leading segments of its alias, leaving out the env and the
+// host name segments.
+func hostPath(h *Host) []string {
+	if len(h.groups) > 0 {
+		return h.groups
+	}
+	path := []string{h.Env}
+	if len(h.hosts) > 2 {
+		path = append(path, h.hosts[:len(h.hosts)-2]...)
+	}
+	return path
+}
+
+func newMenu(list []*Host, templates *promptui.SelectTemplates) *menu {
+	root := &menuNode{Name: menuLabel}
+	for _, h := range list {
+		node := root
+		node.Count++
+		for _, name := range hostPath(h) {
+			node = node.child(name)
+			node.Count++
+		}
+		node.hosts = append(node.hosts, h)
+	}
+
+	m := &menu{
+		root:      root,
+		favorites: &menuNode{Name: favoritesLabel, section: true},
+		recent:    &menuNode{Name: recentLabel, section: true},
+		stack:     []*menuLevel{{node: root}},
+		templates: templates,
+	}
+	m.updateSections()
+	for node := root; len(node.children) == 1 && len(node.hosts) == 0; {
+		node = node.children[0]
+		m.stack = append(m.stack, &menuLevel{node: node})
+	}
+	return m
+}
+
+// updateSections fills the favorites and recent sections from the state file
+// and shows those that are not empty at the top of the root.
+func (m *menu) updateSections() {
+	m.favorites.hosts = favoriteHosts(hosts)
+	m.recent.hosts = recentHosts(hosts)
+	children := make([]*menuNode, 0, len(m.root.children)+2)
+	for _, section := range []*menuNode{m.favorites, m.recent} {
+		section.Count = len(section.hosts)
+		if section.Count > 0 {
+			children = append(children, section)
+		}
+	}
+	for _, c := range m.root.children {
+		if !c.section {
+			children = append(children, c)
+		}
+	}
+	m.root.children = children
+}
+
+func (n *menuNode) child(name string) *menuNode {
+	for _, c := range n.children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &menuNode{Name: name, path: append(append([]string{}, n.path...), name)}
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *menuNode) items() []*menuItem {
+	items := make([]*menuItem, 0, len(n.children)+len(n.hosts))
+	for _, c := range n.children {
+		items = append(items, &menuItem{Node: c})
+	}
+	for _, h := range n.hosts {
+		items = append(items, &menuItem{Host: h})
+	}
+	return items
+}
+
+// label is the breadcrumb of the current level.
+func (m *menu) label() string {
+	crumbs := make([]string, 0, len(m.stack))
+	for _, level := range m.stack {
+		crumbs = append(crumbs, fmt.Sprintf("%s (%d)", level.node.Name, level.node.Count))
+	}
+	label := strings.Join(crumbs, " > ")
+	if m.search {
+		label = menuLabel + " > search"
+	}
+	hints := []string{"ctrl-s favorite"}
+	if hasInventoryScript {
+		hints = append(hints, "ctrl-r refresh")
+	}
+	return label + " (" + strings.Join(hints, ", ") + ")"
+}
+
+// run shows the menu until a host is picked. It returns promptui.ErrInterrupt
+// when the user quits and errRefresh when the hosts should be reloaded.
+func (m *menu) run() (*Host, error) {
+	for {
+		clear[runtime.GOOS]()
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+
+		level := m.stack[len(m.stack)-1]
+		var items []*menuItem
+		searcher := func(query string, index int) bool {
+			return items[index].matches(query)
+		}
+		if m.search {
+			items, searcher = searchItems()
+		} else {
+			items = level.node.items()
+			if len(items) == 0 && len(m.stack) > 1 {
+				m.stack = m.stack[:len(m.stack)-1]
+				continue
+			}
+			for _, h := range hosts {
+				h.marks = nil
+			}
+		}
+
+		in := &menuInput{refreshReader: &refreshReader{consoleReader: input.reader()}, search: m.search}
+		prompt := promptui.Select{
+			Size:              menuSize,
+			Label:             m.label(),
+			Items:             items,
+			Templates:         m.templates,
+			Searcher:          searcher,
+			StartInSearchMode: m.search,
+			Stdin:             in,
+		}
+		if m.search {
+			prompt.Keys = &promptui.SelectKeys{
+				Prev:     promptui.Key{Code: promptui.KeyPrev, Display: promptui.KeyPrevDisplay},
+				Next:     promptui.Key{Code: promptui.KeyNext, Display: promptui.KeyNextDisplay},
+				PageUp:   promptui.Key{Code: promptui.KeyBackward, Display: promptui.KeyBackwardDisplay},
+				PageDown: promptui.Key{Code: promptui.KeyForward, Display: promptui.KeyForwardDisplay},
+				Search:   promptui.Key{Code: noSearchKey, Display: "ctrl-\\"},
+			}
+		}
+
+		cursor, scroll := 0, 0
+		if !m.search {
+			cursor, scroll = level.cursor, level.scroll
+		}
+		idx, _, err := prompt.RunCursorAt(cursor, scroll)
+		_ = in.Close()
+		if err == promptui.ErrInterrupt && in.refresh {
+			return nil, errRefresh
+		}
+		if in.action == actionFavorite {
+			if err == nil && items[idx].Host != nil {
+				state.toggleFavorite(items[idx].Host.Host)
+				if err := state.save(); err != nil {
+					warnings = append(warnings, err.Error())
+				}
+				m.updateSections()
+			}
+			if m.search {
+				input.unread(in.query)
+			} else if err == nil {
+				level.cursor, level.scroll = idx, prompt.ScrollPosition()
+			}
+			continue
+		}
+		if err == promptui.ErrInterrupt {
+			switch in.action {
+			case actionUp:
+				if m.search {
+					m.search = false
+				} else if len(m.stack) > 1 {
+					m.stack = m.stack[:len(m.stack)-1]
+				}
+				continue
+			case actionSearch:
+				m.search = true
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		item := items[idx]
+		if item.Host != nil {
+			return item.Host, nil
+		}
+		level.cursor, level.scroll = idx, prompt.ScrollPosition()
+		m.stack = append(m.stack, &menuLevel{node: item.Node})
+	}
+}
+
+// searchItems returns the rows of the search list with their searcher. promptui
+// keeps the rows in order and only filters them, so the searcher refills the
+// rows with the ranked hosts before keeping the first ones.
+func searchItems() ([]*menuItem, list.Searcher) {
+	items := make([]*menuItem, len(hosts))
+	for i, h := range (hostQuery{}).rank(hosts) {
+		items[i] = &menuItem{Host: h}
+	}
+	matched := 0
+	return items, func(query string, index int) bool {
+		if index == 0 {
+			ranked, err := selectHosts(hosts, query)
+			if err != nil {
+				ranked = nil
+			}
+			for i, h := range ranked {
+				items[i].Host = h
+			}
+			matched = len(ranked)
+		}
+		return index < matched
+	}
+}
+
+// matches tells whether item fits query in the tree, where promptui's own
+// search is not used.
+func (item *menuItem) matches(query string) bool {
+	if item.Node != nil {
+		return strings.Contains(item.Node.Name, query)
+	}
+	ranked, err := selectHosts([]*Host{item.Host}, query)
+	return err == nil && len(ranked) > 0
+}
+
+// menuInput feeds the keyboard to one promptui.Select and turns the keys the
+// menu handles itself into an interrupt that ends the Select, recording which
+// action was asked for. Keys typed after it are given back to input.
+type menuInput struct {
+	*refreshReader
+	search  bool
+	query   []byte
+	escape  int
+	action  menuAction
+	stopped bool
+}
+
+func (m *menuInput) Read(p []byte) (int, error) {
+	if m.action != actionNone {
+		// A Select that did not end on the key of the action, such as enter
+		// on an empty list, is interrupted.
+		if !m.stopped && len(p) > 0 {
+			m.stopped = true
+			p[0] = interruptKey
+			return 1, nil
+		}
+		<-m.done
+		return 0, io.EOF
+	}
+	n, err := m.refreshReader.Read(p)
+	for i := 0; i < n; i++ {
+		b := p[i]
+		switch {
+		case m.escape == 1:
+			m.escape = 0
+			if b == '[' || b == 'O' {
+				m.escape = 2
+			}
+			continue
+		case m.escape == 2:
+			if b >= 0x40 && b <= 0x7e {
+				m.escape = 0
+			}
+			continue
+		case b == escapeKey:
+			m.escape = 1
+			continue
+		}
+
+		typed := b >= 0x20 && b < 0x7f || b >= 0x80
+		switch {
+		case b == favoriteKey:
+			n = m.interrupt(p, i, n, actionFavorite, false)
+			p[i] = enterKey
+			return n, err
+		case b == backspaceKey || b == ctrlHKey:
+			if !m.search || len(m.query) == 0 {
+				return m.interrupt(p, i, n, actionUp, false), err
+			}
+			// promptui shows its rows unfiltered once the query is empty, so
+			// start over from a fresh search list instead.
+			_, size := utf8.DecodeLastRune(m.query)
+			if m.query = m.query[:len(m.query)-size]; len(m.query) == 0 {
+				return m.interrupt(p, i, n, actionSearch, false), err
+			}
+		case b == ctrlUKey:
+			m.query = m.query[:0]
+		case !m.search && b == '/':
+			return m.interrupt(p, i, n, actionSearch, false), err
+		case !m.search && typed && !strings.ContainsRune("jkhl", rune(b)):
+			return m.interrupt(p, i, n, actionSearch, true), err
+		case m.search && typed:
+			m.query = append(m.query, b)
+		}
+	}
+	return n, err
+}
+
+// interrupt ends the read at p[i], replaced by ctrl-c. The keys after it, and
+// p[i] itself when replay is set, are left for the next reader.
+func (m *menuInput) interrupt(p []byte, i, n int, action menuAction, replay bool) int {
+	rest := p[i+1 : n]
+	if replay {
+		rest = p[i:n]
+	}
+	m.c.unread(rest)
+	m.action = action
+	p[i] = interruptKey
+	return i + 1
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+func readLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	in := input.reader()
+	defer in.Close()
+	line := make([]byte, 0, 64)
+	buf := make([]byte, 1)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			continue
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[0])
+	}
+	return strings.TrimSpace(string(line)), nil
+}
+
+// readPassword reads a line without echoing it, like terminal.ReadPassword
+// but through input so that no key is lost to another reader.
+func readPassword() ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if state, err := terminal.MakeRaw(fd); err == nil {
+		defer terminal.Restore(fd, state)
+	}
+
+	in := input.reader()
+	defer in.Close()
+	line := make([]byte, 0, 64)
+	buf := make([]byte, 1)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			continue
+		}
+		switch buf[0] {
+		case '\r', '\n':
+			return line, nil
+		case 0x7f, 0x08:
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+			}
+		case 0x03:
+			return nil, errInterrupted
+		case 0x04:
+			if len(line) == 0 {
+				return nil, io.EOF
+			}
+		case 0x15:
+			line = line[:0]
+		default:
+			line = append(line, buf[0])
+		}
+	}
+}
+
+func pause() {
+	_, _ = readLine("Press Enter to return to the host list...")
+}
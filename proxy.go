@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	maxJumpDepth          = 8
+	defaultConnectTimeout = 5 * time.Second
+	proxyStderrSize       = 4096
+)
+
+// chainConn is a connection tunneled through a jump host. Closing it also
+// closes the jump host client, so closing the last client of a chain tears
+// down every hop.
+type chainConn struct {
+	net.Conn
+	via *ssh.Client
+}
+
+func (c *chainConn) Close() error {
+	err := c.Conn.Close()
+	_ = c.via.Close()
+	return err
+}
+
+// timeoutConn closes a connection that the server does not start talking on
+// within ConnectTimeout, as a TCP connect timeout would for a direct one.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+	timer   *time.Timer
+	expired chan struct{}
+}
+
+func withConnectTimeout(conn net.Conn, timeout time.Duration) *timeoutConn {
+	c := &timeoutConn{Conn: conn, timeout: timeout, expired: make(chan struct{})}
+	c.timer = time.AfterFunc(timeout, func() {
+		close(c.expired)
+		_ = conn.Close()
+	})
+	return c
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.timer.Stop()
+	}
+	return n, err
+}
+
+// wrapErr reports a connection closed by the timeout as such.
+func (c *timeoutConn) wrapErr(err error) error {
+	select {
+	case <-c.expired:
+		return fmt.Errorf("%s: connection timed out after %v", c.RemoteAddr(), c.timeout)
+	default:
+		return err
+	}
+}
+
+// connectTimeout is ConnectTimeout, or 5 seconds when it is not set.
+func (h *Host) connectTimeout() time.Duration {
+	if h.ConnectTimeout > 0 {
+		return time.Duration(h.ConnectTimeout) * time.Second
+	}
+	return defaultConnectTimeout
+}
+
+// dialTimeout opens a channel to addr through via, giving up after timeout.
+func dialTimeout(via *ssh.Client, addr string, timeout time.Duration) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := via.Dial("tcp", addr)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		go func() {
+			if r := <-done; r.conn != nil {
+				_ = r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("connection timed out after %v", timeout)
+	}
+}
+
+// jumpChain returns the hosts to connect through, ending with h itself. The
+// first hop's own ProxyJump is followed as well, like ssh -J does.
+func (h *Host) jumpChain(depth int) ([]*Host, error) {
+	if depth > maxJumpDepth {
+		return nil, fmt.Errorf("%s: too many ProxyJump hops", h.Host)
+	}
+	if h.ProxyJump == "" || strings.ToLower(h.ProxyJump) == "none" {
+		return []*Host{h}, nil
+	}
+
+	chain := make([]*Host, 0)
+	for i, spec := range strings.Split(h.ProxyJump, ",") {
+		hop, err := lookupJumpHost(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			chain = append(chain, hop)
+			continue
+		}
+		first, err := hop.jumpChain(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, first...)
+	}
+	return append(chain, h), nil
+}
+
+// lookupJumpHost resolves a [ssh://][user@]host[:port] hop to its own config
+// entry, falling back to a bare host when the alias is not configured.
+func lookupJumpHost(spec string) (*Host, error) {
+	spec = strings.TrimPrefix(spec, "ssh://")
+	user := ""
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		user, spec = spec[:i], spec[i+1:]
+	}
+	alias, port := spec, 0
+	if host, p, err := net.SplitHostPort(spec); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ProxyJump port %q", p)
+		}
+		alias, port = host, n
+	}
+	alias = strings.Trim(alias, "[]")
+	if alias == "" {
+		return nil, fmt.Errorf("invalid ProxyJump host %q", spec)
+	}
+
+	hop, err := resolveHost(alias)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		hop.User = user
+	}
+	if port != 0 {
+		hop.Port = port
+	}
+	return hop, nil
+}
+
+// connect opens an authenticated client to h, directly or, when via is set,
+// over a channel of the previous hop.
+func (h *Host) connect(via *ssh.Client) (*ssh.Client, error) {
+	auth, closeAgent := h.authMethods()
+	defer closeAgent()
+
+	addr := net.JoinHostPort(h.HostName, strconv.Itoa(h.Port))
+	hostKeyCallback, hostKeyAlgorithms, err := h.hostKeyCallback(addr)
+	if err != nil {
+		if via != nil {
+			_ = via.Close()
+		}
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:              h.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           h.connectTimeout(),
+	}
+
+	if via == nil && h.ProxyCommand != "" && strings.ToLower(h.ProxyCommand) != "none" {
+		conn, err := h.proxyCommand(addr)
+		if err != nil {
+			return nil, err
+		}
+		timed := withConnectTimeout(conn, config.Timeout)
+		c, chans, reqs, err := ssh.NewClientConn(timed, addr, config)
+		if err != nil {
+			_ = conn.Close()
+			return nil, conn.wrapErr(timed.wrapErr(err))
+		}
+		return ssh.NewClient(c, chans, reqs), nil
+	}
+	if via == nil {
+		return ssh.Dial("tcp", addr, config)
+	}
+
+	conn, err := dialTimeout(via, addr, config.Timeout)
+	if err != nil {
+		_ = via.Close()
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	chained := &chainConn{Conn: conn, via: via}
+	timed := withConnectTimeout(chained, config.Timeout)
+	c, chans, reqs, err := ssh.NewClientConn(timed, addr, config)
+	if err != nil {
+		_ = chained.Close()
+		return nil, timed.wrapErr(err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// proxyConn speaks SSH over the stdin and stdout of a ProxyCommand. The child
+// is killed when the connection is closed.
+type proxyConn struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+	stderr  *tailBuffer
+	addr    proxyAddr
+	once    sync.Once
+}
+
+type proxyAddr string
+
+func (a proxyAddr) Network() string { return "proxy" }
+func (a proxyAddr) String() string  { return string(a) }
+
+func (h *Host) proxyCommand(addr string) (*proxyConn, error) {
+	command := h.expandPath(h.ProxyCommand)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", "exec "+command)
+	}
+
+	c := &proxyConn{command: command, cmd: cmd, stderr: &tailBuffer{max: proxyStderrSize}, addr: proxyAddr(addr)}
+	cmd.Stderr = c.stderr
+	var err error
+	if c.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if c.stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ProxyCommand %q: %w", command, err)
+	}
+	return c, nil
+}
+
+func (c *proxyConn) Read(b []byte) (int, error)  { return c.stdout.Read(b) }
+func (c *proxyConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+func (c *proxyConn) Close() error {
+	c.once.Do(func() {
+		_ = c.stdin.Close()
+		_ = c.cmd.Process.Kill()
+		_ = c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *proxyConn) LocalAddr() net.Addr                { return c.addr }
+func (c *proxyConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *proxyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *proxyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *proxyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+func (c *proxyConn) wrapErr(err error) error {
+	stderr := strings.TrimSpace(c.stderr.String())
+	if stderr == "" {
+		return err
+	}
+	return fmt.Errorf("%w\r\nProxyCommand %s: %s", err, c.command, strings.ReplaceAll(stderr, "\n", "\r\n"))
+}
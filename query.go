@@ -0,0 +1,306 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// queryFields are the field qualifiers of a query besides tag and the named
+// groups of aliasPattern.
+var queryFields = map[string]bool{
+	"id":       true,
+	"env":      true,
+	"user":     true,
+	"ip":       true,
+	"hostname": true,
+	"alias":    true,
+	"port":     true,
+	"group":    true,
+	"comment":  true,
+	"source":   true,
+	"tag":      true,
+}
+
+// queryTerm is one word of a query. Words without a field are matched
+// fuzzily against every field, unless quoted, negated or a /regexp/.
+type queryTerm struct {
+	field   string
+	text    string
+	pattern *regexp.Regexp
+	phrase  bool
+	negate  bool
+}
+
+// hostQuery selects hosts by all of its terms. It is written as words such
+// as prod, "jump box", /^db/, 42, id:42, env:prod, user:root, ip:10.2.*,
+// tag:db or role=db, each of which can be negated with a leading -.
+type hostQuery []*queryTerm
+
+func isQueryField(name string) bool {
+	return queryFields[name] || aliasPattern != nil && aliasPattern.SubexpIndex(name) > 0
+}
+
+// parseQuery parses s into a query. A quote or a regexp left open runs to the
+// end of s, so that a query can be parsed while it is typed.
+func parseQuery(s string) (hostQuery, error) {
+	q := make(hostQuery, 0)
+	r := []rune(s)
+	for i := 0; ; {
+		for i < len(r) && unicode.IsSpace(r[i]) {
+			i++
+		}
+		if i == len(r) {
+			return q, nil
+		}
+
+		t := &queryTerm{}
+		if r[i] == '-' && i+1 < len(r) && !unicode.IsSpace(r[i+1]) {
+			t.negate = true
+			i++
+		}
+		j := i
+		for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '_') {
+			j++
+		}
+		if j < len(r) && r[j] == ':' && isQueryField(strings.ToLower(string(r[i:j]))) {
+			t.field = strings.ToLower(string(r[i:j]))
+			i = j + 1
+		}
+
+		switch {
+		case i < len(r) && r[i] == '"':
+			t.phrase = true
+			t.text, i = readUntil(r, i+1, '"')
+		case i < len(r) && r[i] == '/':
+			expr := ""
+			expr, i = readUntil(r, i+1, '/')
+			re, err := regexp.Compile("(?i)" + expr)
+			if err != nil {
+				return nil, fmt.Errorf("bad regexp /%s/: %v", expr, err)
+			}
+			t.pattern = re
+		default:
+			j := i
+			for j < len(r) && !unicode.IsSpace(r[j]) {
+				j++
+			}
+			t.text, i = string(r[i:j]), j
+		}
+
+		if t.field == "" && !t.phrase && t.pattern == nil {
+			if key, value, ok := parseTag(t.text); ok {
+				t.field, t.text = "tag", key
+				if value != "" {
+					t.text += "=" + value
+				}
+			}
+		}
+		if t.text != "" || t.pattern != nil {
+			q = append(q, t)
+		}
+	}
+}
+
+// readUntil reads r from i up to an unescaped end rune and returns the text
+// with the position after end.
+func readUntil(r []rune, i int, end rune) (string, int) {
+	var b strings.Builder
+	for ; i < len(r); i++ {
+		switch {
+		case r[i] == '\\' && i+1 < len(r) && r[i+1] == end:
+			i++
+			if end == '/' {
+				b.WriteRune('\\')
+			}
+		case r[i] == end:
+			return b.String(), i + 1
+		}
+		b.WriteRune(r[i])
+	}
+	return b.String(), i
+}
+
+// fieldValues returns the values of h that the field qualifier name selects.
+func (h *Host) fieldValues(name string) []string {
+	switch name {
+	case "id":
+		return []string{strconv.Itoa(h.Index)}
+	case "env":
+		return []string{h.Env}
+	case "user":
+		return []string{h.User}
+	case "ip", "hostname":
+		return []string{h.HostName}
+	case "alias":
+		return []string{h.Host}
+	case "port":
+		return []string{strconv.Itoa(h.Port)}
+	case "group":
+		return hostPath(h)
+	case "comment":
+		return []string{h.Comment}
+	case "source":
+		return []string{h.Source}
+	case "tag":
+		values := make([]string, 0, len(h.Tags))
+		for k, v := range h.Tags {
+			if v == "" {
+				values = append(values, k)
+			} else {
+				values = append(values, k+"="+v)
+			}
+		}
+		return values
+	}
+	return []string{h.Fields[name]}
+}
+
+// match tells whether h fits the term, with the fuzzy score of a plain word.
+func (t *queryTerm) match(h *Host) (int, bool) {
+	if t.field == "" && !t.phrase && t.pattern == nil && !t.negate {
+		score, field, positions, ok := h.fuzzyScore(t.text)
+		if ok && field != "" {
+			h.marks[field] = append(h.marks[field], positions...)
+		}
+		return score, ok
+	}
+
+	values := make([]string, 0)
+	if t.field == "" {
+		for _, f := range h.searchFields() {
+			values = append(values, f[1])
+		}
+	} else {
+		values = h.fieldValues(t.field)
+	}
+	found := false
+	for _, v := range values {
+		if t.matchValue(v) {
+			found = true
+			break
+		}
+	}
+	return 0, found != t.negate
+}
+
+func (t *queryTerm) matchValue(v string) bool {
+	switch {
+	case t.pattern != nil:
+		return t.pattern.MatchString(v)
+	case t.field == "" || t.field == "comment" && !strings.ContainsAny(t.text, "*?"):
+		return strings.Contains(strings.ToLower(v), strings.ToLower(t.text))
+	case t.field == "tag" && !strings.Contains(t.text, "="):
+		// tag:db asks for a tag named db or a tag whose value is db.
+		kv := strings.SplitN(v, "=", 2)
+		return matchPattern(t.text, kv[0]) || len(kv) == 2 && matchPattern(t.text, kv[1])
+	}
+	return matchPattern(t.text, v)
+}
+
+// match tells whether h fits every term of q and returns its score. The
+// characters matched by plain words are recorded for Highlight.
+func (q hostQuery) match(h *Host) (int, bool) {
+	h.marks = make(map[string][]int)
+	total := 0
+	for _, t := range q {
+		score, ok := t.match(h)
+		if !ok {
+			h.marks = nil
+			return 0, false
+		}
+		total += score
+	}
+	return total, true
+}
+
+// rank returns the hosts of list that fit q, best first and then by
+// frecency.
+func (q hostQuery) rank(list []*Host) []*Host {
+	scores := make(map[*Host]int)
+	ranked := make([]*Host, 0, len(list))
+	for _, h := range list {
+		if s, ok := q.match(h); ok {
+			scores[h] = s
+			ranked = append(ranked, h)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return state.frecency(a.Host) > state.frecency(b.Host)
+	})
+	return ranked
+}
+
+// selectHosts returns the hosts of list that fit query, ranked.
+func selectHosts(list []*Host, query string) ([]*Host, error) {
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.rank(list), nil
+}
+
+// parseQueryArgs parses the flags of fs in args and the query made of the
+// other arguments, so that a negated word such as -env:dev is not taken for
+// an unknown flag.
+func parseQueryArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	flags := make([]string, 0)
+	words := make([]string, 0)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			words = append(words, args[i+1:]...)
+			break
+		}
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		f := fs.Lookup(name)
+		if !strings.HasPrefix(arg, "-") || f == nil && name != "h" && name != "help" {
+			words = append(words, arg)
+			continue
+		}
+		flags = append(flags, arg)
+		if f == nil || strings.Contains(arg, "=") {
+			continue
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			continue
+		}
+		if i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+	return words, fs.Parse(flags)
+}
+
+// joinQuery makes a query of command line arguments. An argument with spaces
+// was quoted in the shell, so it stays one phrase.
+func joinQuery(args []string) string {
+	words := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.ContainsAny(arg, " \t") && !strings.ContainsAny(arg, "\"/") {
+			arg = strconv.Quote(arg)
+		}
+		words = append(words, arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// findHosts returns the host whose alias or ID is query, or else the hosts
+// that fit query.
+func findHosts(list []*Host, query string) ([]*Host, error) {
+	for _, h := range list {
+		if h.Host == query || strconv.Itoa(h.Index) == query {
+			return []*Host{h}, nil
+		}
+	}
+	return selectHosts(list, query)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func (t *queryTerm) String() string {
+	s := ""
+	if t.negate {
+		s = "-"
+	}
+	if t.field != "" {
+		s += t.field + ":"
+	}
+	switch {
+	case t.pattern != nil:
+		return s + "/" + t.pattern.String() + "/"
+	case t.phrase:
+		return s + `"` + t.text + `"`
+	}
+	return s + t.text
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{}, false},
+		{"  prod  web ", []string{"prod", "web"}, false},
+		{"env:prod", []string{"env:prod"}, false},
+		{"ENV:prod", []string{"env:prod"}, false},
+		{"-env:dev", []string{"-env:dev"}, false},
+		{"-web", []string{"-web"}, false},
+		{"a - b", []string{"a", "-", "b"}, false},
+		{"nope:x", []string{"nope:x"}, false},
+		{`"jump box" db`, []string{`"jump box"`, "db"}, false},
+		{`comment:"front end`, []string{`comment:"front end"`}, false},
+		{`"say \"hi\""`, []string{`"say "hi""`}, false},
+		{"/^db[0-9]/ prod", []string{"/(?i)^db[0-9]/", "prod"}, false},
+		{`/a\/b/`, []string{`/(?i)a\/b/`}, false},
+		{"/(/", nil, true},
+		{"role=db", []string{"tag:role=db"}, false},
+		{"#primary", []string{"tag:primary"}, false},
+		{"-role=db", []string{"-tag:role=db"}, false},
+		{"1+1=2", []string{"1+1=2"}, false},
+		{`"role=db"`, []string{`"role=db"`}, false},
+		{"ip:10.0.* port:22", []string{"ip:10.0.*", "port:22"}, false},
+		{"tag:", []string{}, false},
+	}
+	for _, tt := range tests {
+		q, err := parseQuery(tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseQuery(%q) error %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got := make([]string, 0, len(q))
+		for _, term := range q {
+			got = append(got, term.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	web := newHost("web_prod")
+	web.Index, web.Env, web.HostName, web.User = 1, "prod", "10.0.0.1", "root"
+	web.Comment, web.Tags = "nginx front", map[string]string{"role": "web", "primary": ""}
+	db := newHost("db_dev")
+	db.Index, db.Env, db.HostName, db.User = 2, "dev", "10.0.1.1", "dba"
+	db.Comment, db.Tags = "postgres", map[string]string{"role": "db"}
+	list := []*Host{web, db}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"web_prod", "db_dev"}},
+		{"env:prod", []string{"web_prod"}},
+		{"-env:prod", []string{"db_dev"}},
+		{"user:d*", []string{"db_dev"}},
+		{"ip:10.0.1.*", []string{"db_dev"}},
+		{"id:2", []string{"db_dev"}},
+		{"role=db", []string{"db_dev"}},
+		{"tag:primary", []string{"web_prod"}},
+		{"tag:db", []string{"db_dev"}},
+		{"tag:role", []string{"web_prod", "db_dev"}},
+		{"-#primary", []string{"db_dev"}},
+		{`"nginx front"`, []string{"web_prod"}},
+		{`"front nginx"`, []string{}},
+		{"/^db_/", []string{"db_dev"}},
+		{"pgres", []string{"db_dev"}},
+		{"wp", []string{"web_prod"}},
+		{"prod nginx", []string{"web_prod"}},
+		{"prod postgres", []string{}},
+	}
+	for _, tt := range tests {
+		ranked, err := selectHosts(list, tt.query)
+		if err != nil {
+			t.Errorf("selectHosts(%q): %v", tt.query, err)
+			continue
+		}
+		got := make([]string, 0, len(ranked))
+		for _, h := range ranked {
+			got = append(got, h.Host)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectHosts(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryArgs(t *testing.T) {
+	tests := []struct {
+		args   []string
+		format string
+		words  []string
+	}{
+		{[]string{"prod"}, "json", []string{"prod"}},
+		{[]string{"--format", "csv", "prod"}, "csv", []string{"prod"}},
+		{[]string{"prod", "-format=csv", "-env:dev"}, "csv", []string{"prod", "-env:dev"}},
+		{[]string{"-web", "--", "--format"}, "json", []string{"-web", "--format"}},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		format := fs.String("format", "json", "")
+		words, err := parseQueryArgs(fs, tt.args)
+		if err != nil {
+			t.Errorf("parseQueryArgs(%q): %v", tt.args, err)
+			continue
+		}
+		if *format != tt.format || strings.Join(words, " ") != strings.Join(tt.words, " ") {
+			t.Errorf("parseQueryArgs(%q) = %q, format %q, want %q, format %q", tt.args, words, *format, tt.words, tt.format)
+		}
+	}
+}
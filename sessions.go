@@ -0,0 +1,536 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// mobaSSH is the session type MobaXterm uses for SSH bookmarks.
+const mobaSSH = "109"
+
+var windowsPath = regexp.MustCompile(`^[A-Za-z]:[\\/]|\\|_ProfileDir_|_CurrentDrive_`)
+
+// importReport collects what could not be carried over from a session export.
+type importReport struct {
+	notes   []string
+	renamed []string
+	skipped int
+}
+
+func (r *importReport) add(session, format string, args ...interface{}) {
+	r.notes = append(r.notes, session+": "+fmt.Sprintf(format, args...))
+}
+
+// sessionImport builds an inventory from sessions kept in nested folders. The
+// top folder of a session becomes its Env.
+type sessionImport struct {
+	inv    *inventory
+	groups map[string]*inventoryGroup
+	names  map[string]bool
+	report *importReport
+}
+
+func newSessionImport() *sessionImport {
+	return &sessionImport{
+		inv:    &inventory{},
+		groups: make(map[string]*inventoryGroup),
+		names:  make(map[string]bool),
+		report: &importReport{},
+	}
+}
+
+// add files h under folders. Session names may repeat across folders and
+// contain spaces, neither of which works for an alias, so they are adjusted.
+func (s *sessionImport) add(folders []string, session string, h *inventoryHost) {
+	h.session = h.Name
+	base := strings.Join(strings.Fields(h.Name), "-")
+	if s.names[base] && len(folders) > 0 {
+		base += "_" + folders[0]
+	}
+	h.Name = base
+	for i := 2; s.names[h.Name]; i++ {
+		h.Name = fmt.Sprintf("%s_%d", base, i)
+	}
+	s.names[h.Name] = true
+	if h.Name != session {
+		s.report.renamed = append(s.report.renamed, session+": imported as "+h.Name)
+	}
+	if h.IdentityFile != "" && windowsPath.MatchString(h.IdentityFile) {
+		s.report.add(session, "identity file %s is a Windows path, adjust identity_file", h.IdentityFile)
+	}
+
+	if len(folders) == 0 {
+		s.inv.Hosts = append(s.inv.Hosts, h)
+		return
+	}
+	s.group(folders).Hosts = append(s.group(folders).Hosts, h)
+}
+
+func (s *sessionImport) group(folders []string) *inventoryGroup {
+	key := strings.Join(folders, "/")
+	if g, ok := s.groups[key]; ok {
+		return g
+	}
+	g := &inventoryGroup{Name: folders[len(folders)-1]}
+	s.groups[key] = g
+	if len(folders) == 1 {
+		s.inv.Groups = append(s.inv.Groups, g)
+	} else {
+		parent := s.group(folders[:len(folders)-1])
+		parent.Children = append(parent.Children, g)
+	}
+	return g
+}
+
+func (s *sessionImport) skip(session, format string, args ...interface{}) {
+	s.report.skipped++
+	s.report.add(session, "skipped, "+format, args...)
+}
+
+// importXshell reads Xshell .xsh session files: a single file, a sessions
+// directory or an .xts export archive. Sub-directories are groups.
+func importXshell(path string) (*inventory, *importReport, error) {
+	s := newSessionImport()
+	path = expandHome(path)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case fi.IsDir():
+		err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() || !strings.EqualFold(filepath.Ext(p), ".xsh") {
+				return err
+			}
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(path, p)
+			s.xshellSession(rel, data)
+			return nil
+		})
+	case ext == ".xts" || ext == ".zip":
+		err = s.xshellArchive(path)
+	default:
+		var data []byte
+		if data, err = ioutil.ReadFile(path); err == nil {
+			s.xshellSession(filepath.Base(path), data)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.inv, s.report, nil
+}
+
+func (s *sessionImport) xshellArchive(path string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if !strings.EqualFold(filepath.Ext(f.Name), ".xsh") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+		s.xshellSession(f.Name, data)
+	}
+	return nil
+}
+
+func (s *sessionImport) xshellSession(rel string, data []byte) {
+	parts := splitFolders(strings.TrimSuffix(rel, filepath.Ext(rel)))
+	folders, name := parts[:len(parts)-1], parts[len(parts)-1]
+	ini := parseINI(decodeText(data))
+	get := func(section, key string) string {
+		return ini[strings.ToUpper(section)][strings.ToLower(key)]
+	}
+
+	if p := get("CONNECTION", "Protocol"); p != "" && !strings.EqualFold(p, "SSH") {
+		s.skip(name, "protocol %s", p)
+		return
+	}
+	host := &inventoryHost{Name: name, HostName: get("CONNECTION", "Host"), Comment: get("CONNECTION", "Description")}
+	if host.HostName == "" {
+		s.skip(name, "no host")
+		return
+	}
+	if port := get("CONNECTION", "Port"); port != "" && port != "22" {
+		host.Port, _ = strconv.Atoi(port)
+	}
+	host.User = get("CONNECTION:AUTHENTICATION", "UserName")
+
+	if get("CONNECTION:AUTHENTICATION", "Password") != "" {
+		s.report.add(name, "saved password not imported")
+	}
+	if key := get("CONNECTION:AUTHENTICATION", "UserKey"); key != "" {
+		s.report.add(name, "user key %s is in Xshell's key store, export it and set identity_file", key)
+	}
+	if get("CONNECTION:AUTHENTICATION", "UseExpectSend") == "1" {
+		s.report.add(name, "login script not imported")
+	}
+	if proxy := get("CONNECTION:PROXY", "Proxy"); proxy != "" {
+		s.report.add(name, "proxy %s not imported", proxy)
+	}
+	if get("CONNECTION:SSH", "ForwardX11") == "1" {
+		s.report.add(name, "X11 forwarding not imported")
+	}
+	for key, value := range ini["CONNECTION:SSH:TUNNELING"] {
+		if strings.HasSuffix(key, "count") && value != "" && value != "0" {
+			s.report.add(name, "port forwarding not imported")
+			break
+		}
+	}
+	s.add(folders, name, host)
+}
+
+// importSecureCRT reads the XML written by SecureCRT's Export Settings, or its
+// per-session .ini files: a single file or the Sessions directory.
+func importSecureCRT(path string) (*inventory, *importReport, error) {
+	s := newSessionImport()
+	path = expandHome(path)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case fi.IsDir():
+		err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() || !strings.EqualFold(filepath.Ext(p), ".ini") {
+				return err
+			}
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(path, p)
+			parts := splitFolders(strings.TrimSuffix(rel, filepath.Ext(rel)))
+			s.crtSession(parts[:len(parts)-1], parts[len(parts)-1], parseCRTINI(decodeText(data)))
+			return nil
+		})
+	case strings.EqualFold(filepath.Ext(path), ".xml"):
+		err = s.crtXML(path)
+	default:
+		var data []byte
+		if data, err = ioutil.ReadFile(path); err == nil {
+			name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			s.crtSession(nil, name, parseCRTINI(decodeText(data)))
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.inv, s.report, nil
+}
+
+type crtKey struct {
+	Name    string     `xml:"name,attr"`
+	Keys    []crtKey   `xml:"key"`
+	Strings []crtValue `xml:"string"`
+	Dwords  []crtValue `xml:"dword"`
+	Arrays  []crtArray `xml:"array"`
+}
+
+type crtValue struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type crtArray struct {
+	Name    string   `xml:"name,attr"`
+	Strings []string `xml:"string"`
+}
+
+func (s *sessionImport) crtXML(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	root := crtKey{}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	sessions := findCRTKey(root.Keys, "Sessions")
+	if sessions == nil {
+		return fmt.Errorf("%s: no Sessions in SecureCRT export", path)
+	}
+	s.crtFolder(nil, sessions.Keys)
+	return nil
+}
+
+func findCRTKey(keys []crtKey, name string) *crtKey {
+	for i := range keys {
+		if keys[i].Name == name {
+			return &keys[i]
+		}
+		if k := findCRTKey(keys[i].Keys, name); k != nil {
+			return k
+		}
+	}
+	return nil
+}
+
+func (s *sessionImport) crtFolder(folders []string, keys []crtKey) {
+	for _, k := range keys {
+		values := make(map[string]string)
+		for _, v := range k.Strings {
+			values[v.Name] = v.Value
+		}
+		for _, v := range k.Dwords {
+			values[v.Name] = v.Value
+		}
+		for _, a := range k.Arrays {
+			values[a.Name] = strings.Join(a.Strings, "\n")
+		}
+		if _, ok := values["Hostname"]; ok {
+			s.crtSession(folders, k.Name, values)
+			continue
+		}
+		s.crtFolder(append(append([]string{}, folders...), k.Name), k.Keys)
+	}
+}
+
+func (s *sessionImport) crtSession(folders []string, name string, values map[string]string) {
+	if name == "Default" || name == "__FolderData__" {
+		return
+	}
+	if p := values["Protocol Name"]; p != "" && p != "SSH2" && p != "SSH1" {
+		s.skip(name, "protocol %s", p)
+		return
+	}
+	host := &inventoryHost{Name: name, HostName: values["Hostname"], inventoryOptions: inventoryOptions{User: values["Username"]}}
+	if host.HostName == "" {
+		s.skip(name, "no host")
+		return
+	}
+	if port, err := strconv.Atoi(values["[SSH2] Port"]); err == nil && port != 22 {
+		host.Port = port
+	}
+	if desc := strings.TrimSpace(values["Description"]); desc != "" {
+		host.Comment = strings.Join(strings.Fields(desc), " ")
+	}
+	if key := values["Identity Filename V2"]; key != "" {
+		host.IdentityFile = strings.SplitN(key, "::", 2)[0]
+	}
+
+	if values["Use Global Public Key"] == "1" {
+		s.report.add(name, "uses SecureCRT's global public key, set identity_file")
+	}
+	if values["Password V2"] != "" || values["Password"] != "" {
+		s.report.add(name, "saved password not imported")
+	}
+	if fw := values["Firewall Name"]; fw != "" && fw != "None" {
+		s.report.add(name, "firewall %s not imported", fw)
+	}
+	for _, table := range []string{"Port Forward Table V2", "Reverse Forward Table V2"} {
+		if v := values[table]; v != "" && v != "0" {
+			s.report.add(name, "%s not imported", strings.ToLower(strings.TrimSuffix(table, " V2")))
+		}
+	}
+	if values["Use Script File"] == "1" {
+		s.report.add(name, "logon script not imported")
+	}
+	s.add(folders, name, host)
+}
+
+// parseCRTINI reads a SecureCRT session file, whose lines look like
+// S:"Hostname"=example.com or D:"[SSH2] Port"=00000016. Arrays list their
+// items on the lines that follow.
+func parseCRTINI(text string) map[string]string {
+	values := make(map[string]string)
+	array := ""
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) < 4 || line[1] != ':' || line[2] != '"' {
+			if array != "" && strings.TrimSpace(line) != "" {
+				values[array] = strings.TrimPrefix(values[array]+"\n"+strings.TrimSpace(line), "\n")
+			}
+			continue
+		}
+		array = ""
+		end := strings.Index(line[3:], `"=`)
+		if end < 0 {
+			continue
+		}
+		name, value := line[3:3+end], line[3+end+2:]
+		switch line[0] {
+		case 'D':
+			if n, err := strconv.ParseUint(value, 16, 32); err == nil {
+				value = strconv.FormatUint(n, 10)
+			}
+		case 'Z':
+			array, value = name, ""
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// importMobaXterm reads a .mxtsessions export. Bookmark sections carry their
+// folder in SubRep; each other key is a session.
+func importMobaXterm(path string) (*inventory, *importReport, error) {
+	data, err := ioutil.ReadFile(expandHome(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	s := newSessionImport()
+	for _, section := range parseINISections(decodeText(data)) {
+		if !strings.HasPrefix(strings.ToLower(section.name), "bookmarks") {
+			continue
+		}
+		var folders []string
+		for _, kv := range section.values {
+			if strings.EqualFold(kv[0], "SubRep") && kv[1] != "" {
+				folders = splitFolders(kv[1])
+			}
+		}
+		for _, kv := range section.values {
+			if strings.EqualFold(kv[0], "SubRep") || strings.EqualFold(kv[0], "ImgNum") {
+				continue
+			}
+			s.mobaSession(folders, kv[0], kv[1])
+		}
+	}
+	return s.inv, s.report, nil
+}
+
+// mobaSession decodes "#109#0%host%port%user%..." where the fields after the
+// user are, among others, the startup command (7), the SSH gateway host, port
+// and user (8-10) and the private key (14).
+func (s *sessionImport) mobaSession(folders []string, name, value string) {
+	parts := strings.Split(value, "#")
+	if len(parts) < 3 {
+		s.skip(name, "unrecognized bookmark")
+		return
+	}
+	if parts[1] != mobaSSH {
+		s.skip(name, "session type %s is not SSH", parts[1])
+		return
+	}
+	fields := strings.Split(parts[2], "%")
+	field := func(i int) string {
+		if i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+
+	host := &inventoryHost{Name: name, HostName: field(1), inventoryOptions: inventoryOptions{User: field(3), IdentityFile: field(14)}}
+	if host.HostName == "" {
+		s.skip(name, "no host")
+		return
+	}
+	if port, err := strconv.Atoi(field(2)); err == nil && port != 22 {
+		host.Port = port
+	}
+	if gateway := field(8); gateway != "" {
+		if user := field(10); user != "" {
+			gateway = user + "@" + gateway
+		}
+		if port := field(9); port != "" && port != "22" {
+			gateway += ":" + port
+		}
+		host.Bastion = gateway
+	}
+	if cmd := field(7); cmd != "" {
+		s.report.add(name, "startup command %q not imported", cmd)
+	}
+	s.add(folders, name, host)
+}
+
+type iniSection struct {
+	name   string
+	values [][2]string
+}
+
+// parseINISections reads an INI file keeping the order of sections and keys.
+func parseINISections(text string) []*iniSection {
+	sections := []*iniSection{{}}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			sections = append(sections, &iniSection{name: line[1 : len(line)-1]})
+		default:
+			i := strings.Index(line, "=")
+			if i < 0 {
+				continue
+			}
+			cur := sections[len(sections)-1]
+			cur.values = append(cur.values, [2]string{strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])})
+		}
+	}
+	return sections
+}
+
+// parseINI reads an INI file into upper-cased sections of lower-cased keys.
+func parseINI(text string) map[string]map[string]string {
+	ini := make(map[string]map[string]string)
+	for _, section := range parseINISections(text) {
+		name := strings.ToUpper(section.name)
+		if ini[name] == nil {
+			ini[name] = make(map[string]string)
+		}
+		for _, kv := range section.values {
+			ini[name][strings.ToLower(kv[0])] = kv[1]
+		}
+	}
+	return ini
+}
+
+// decodeText returns data as a string, converting from UTF-16 when it starts
+// with a byte order mark, as Windows programs often write their files.
+func decodeText(data []byte) string {
+	switch {
+	case len(data) >= 2 && data[0] == 0xff && data[1] == 0xfe:
+		return decodeUTF16(data[2:], false)
+	case len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff:
+		return decodeUTF16(data[2:], true)
+	case len(data) >= 3 && data[0] == 0xef && data[1] == 0xbb && data[2] == 0xbf:
+		return string(data[3:])
+	}
+	return string(data)
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func splitFolders(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+}
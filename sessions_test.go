@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func writeSessions(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func utf16LE(s string) []byte {
+	b := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return b
+}
+
+func checkImport(t *testing.T, inv *inventory, report *importReport, hosts, notes []string) {
+	t.Helper()
+	if got := describeInventory(t, inv); !reflect.DeepEqual(got, hosts) {
+		t.Errorf("hosts:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(hosts, "\n"))
+	}
+	got := append(append([]string{}, report.renamed...), report.notes...)
+	sort.Strings(got)
+	sort.Strings(notes)
+	if !reflect.DeepEqual(got, notes) {
+		t.Errorf("notes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(notes, "\n"))
+	}
+}
+
+func TestImportXshell(t *testing.T) {
+	dir := writeSessions(t, map[string][]byte{
+		"my box.xsh": []byte("[CONNECTION]\r\nHost=10.5.0.1\r\n"),
+		"telnet.xsh": []byte("[CONNECTION]\r\nHost=10.5.0.2\r\nProtocol=TELNET\r\n"),
+		"prod/web01.xsh": utf16LE("[CONNECTION]\r\nHost=10.0.0.1\r\nPort=22\r\nDescription=nginx front\r\n" +
+			"[CONNECTION:AUTHENTICATION]\r\nUserName=root\r\nPassword=secret\r\n"),
+		"prod/db/web01.xsh": []byte("[CONNECTION]\nHost=10.0.1.1\nPort=2222\n" +
+			"[CONNECTION:AUTHENTICATION]\nUserName=dba\n[CONNECTION:PROXY]\nProxy=corp\n"),
+		"readme.txt": []byte("not a session"),
+	})
+	inv, report, err := importXshell(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImport(t, inv, report, []string{
+		"my-box " + os.Getenv("USER") + "@10.5.0.1:22",
+		"prod web01_prod root@10.0.0.1:22",
+		"prod/db web01 dba@10.0.1.1:2222",
+	}, []string{
+		"my box: imported as my-box",
+		"telnet: skipped, protocol TELNET",
+		"web01: imported as web01_prod",
+		"web01: proxy corp not imported",
+		"web01: saved password not imported",
+	})
+	if report.skipped != 1 {
+		t.Errorf("%d sessions skipped, want 1", report.skipped)
+	}
+}
+
+func TestImportSecureCRT(t *testing.T) {
+	dir := writeSessions(t, map[string][]byte{
+		"Default.ini": []byte(`S:"Hostname"=default` + "\n"),
+		"prod/db01.ini": []byte(strings.Join([]string{
+			`S:"Hostname"=10.7.0.1`,
+			`S:"Protocol Name"=SSH2`,
+			`D:"[SSH2] Port"=000008ae`,
+			`S:"Username"=ops`,
+			`S:"Identity Filename V2"=/keys/ops::rawkey`,
+			`Z:"Description"=00000002`,
+			` db server`,
+			` primary`,
+			`S:"Firewall Name"=socks`,
+		}, "\r\n")),
+		"serial.ini": []byte(`S:"Hostname"=` + "\n" + `S:"Protocol Name"=Serial` + "\n"),
+	})
+	inv, report, err := importSecureCRT(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImport(t, inv, report, []string{
+		"prod db01 ops@10.7.0.1:2222 /keys/ops",
+	}, []string{
+		"db01: firewall socks not imported",
+		"serial: skipped, protocol Serial",
+	})
+	if db := inv.Groups[0].Hosts[0]; db.Comment != "db server primary" {
+		t.Errorf("comment %q, want %q", db.Comment, "db server primary")
+	}
+
+	xmlPath := filepath.Join(writeSessions(t, map[string][]byte{"export.xml": []byte(`<?xml version="1.0"?>
+<VanDyke version="3.0">
+  <key name="Sessions">
+    <key name="Default"><string name="Hostname"></string></key>
+    <key name="prod">
+      <key name="web">
+        <key name="web01">
+          <string name="Hostname">10.0.0.1</string>
+          <string name="Username">root</string>
+          <dword name="[SSH2] Port">22</dword>
+          <array name="Description"><string>nginx</string></array>
+        </key>
+      </key>
+    </key>
+  </key>
+</VanDyke>`)}), "export.xml")
+	inv, report, err = importSecureCRT(xmlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImport(t, inv, report, []string{"prod/web web01 root@10.0.0.1:22"}, []string{})
+}
+
+func TestImportMobaXterm(t *testing.T) {
+	path := filepath.Join(writeSessions(t, map[string][]byte{"sessions.mxtsessions": []byte(strings.Join([]string{
+		`[Bookmarks]`,
+		`SubRep=`,
+		`ImgNum=42`,
+		`web01=#109#0%10.0.0.1%22%root%%-1%-1%%%22%%0%0%0%%%-1%0%0%0%%1080%%0%0%1#MobaFont%10#0# #-1`,
+		``,
+		`[Bookmarks_1]`,
+		`SubRep=prod\db`,
+		`ImgNum=41`,
+		`db01=#109#0%10.0.1.1%2222%dba%%-1%-1%uptime%bastion.example.com%2022%jump%0%0%0%/keys/db%%-1#MobaFont#0# #-1`,
+		`win=#91#4%10.0.2.2%3389%admin%0%0#MobaFont#0# #-1`,
+		`bad=nothing`,
+	}, "\r\n"))}), "sessions.mxtsessions")
+	inv, report, err := importMobaXterm(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImport(t, inv, report, []string{
+		"web01 root@10.0.0.1:22",
+		"prod/db db01 dba@10.0.1.1:2222 /keys/db jump@bastion.example.com:2022",
+	}, []string{
+		`db01: startup command "uptime" not imported`,
+		"win: skipped, session type 91 is not SSH",
+		"bad: skipped, unrecognized bookmark",
+	})
+}
+
+func TestImportSSHConfig(t *testing.T) {
+	s := newSessionImport()
+	s.add(nil, "my box", &inventoryHost{Name: "my box", HostName: "10.5.0.1"})
+	s.add([]string{"prod"}, "web01", &inventoryHost{Name: "web01", HostName: "10.0.0.1", Comment: "nginx front",
+		inventoryOptions: inventoryOptions{User: "root", Tags: inventoryTags{"role": "web"}}})
+	s.add([]string{"prod", "db"}, "web01", &inventoryHost{Name: "web01", HostName: "10.0.1.1",
+		inventoryOptions: inventoryOptions{Port: 2222, IdentityFile: "/my keys/db"}})
+	s.add([]string{"prod", "db"}, "web_01", &inventoryHost{Name: "web_01", HostName: "10.0.1.2"})
+	s.add([]string{"prod", "db"}, "web-01", &inventoryHost{Name: "web-01", HostName: "10.0.1.3"})
+	s.add([]string{"dev"}, "db01", &inventoryHost{Name: "db01"})
+
+	var b bytes.Buffer
+	if err := importSSHConfig(&b, "sessions", s.inv); err != nil {
+		t.Fatal(err)
+	}
+	want := `Host my-box
+    HostName 10.5.0.1
+
+Host web01_prod # nginx front role=web
+    HostName 10.0.0.1
+    User root
+
+Host db_web01_prod
+    HostName 10.0.1.1
+    Port 2222
+    IdentityFile "/my keys/db"
+
+Host db_web-01_prod
+    HostName 10.0.1.2
+
+Host db_web-01-2_prod
+    HostName 10.0.1.3
+
+Host db01_dev
+    HostName db01
+`
+	if got := b.String(); got != want {
+		t.Errorf("importSSHConfig:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{[]byte("plain"), "plain"},
+		{[]byte("\xef\xbb\xbfbom"), "bom"},
+		{utf16LE("机器列表"), "机器列表"},
+		{[]byte{0xfe, 0xff, 0, 'h', 0, 'i'}, "hi"},
+	}
+	for _, tt := range tests {
+		if got := decodeText(tt.data); got != tt.want {
+			t.Errorf("decodeText(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runShow prints every effective option of the host named by args, an alias,
+// an ID or a query that fits one host, and returns the exit status.
+func runShow(paths []string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: jump show <alias|id|query>")
+		return exitUsage
+	}
+	if err := loadHosts(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		return exitError
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
+	query := joinQuery(args)
+	list, err := findHosts(hosts, query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "jump: %v\n", err)
+		return exitUsage
+	}
+	switch {
+	case len(list) == 0:
+		fmt.Fprintf(os.Stderr, "jump: no host matches %s\n", query)
+		return exitNoMatch
+	case len(list) > 1:
+		fmt.Fprintf(os.Stderr, "jump: %d hosts match %s:\n", len(list), query)
+		for _, h := range list {
+			fmt.Fprintf(os.Stderr, "  %d\t%s\n", h.Index, h.Host)
+		}
+		return exitAmbiguous
+	}
+
+	h := list[0]
+	fmt.Printf("# id: %d\n# env: %s\n", h.Index, h.Env)
+	if len(h.groups) > 0 {
+		fmt.Printf("# groups: %s\n", strings.Join(h.groups, "/"))
+	}
+	names := make([]string, 0, len(h.Fields))
+	for name := range h.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("# %s: %s\n", name, h.Fields[name])
+	}
+	if len(h.Tags) > 0 {
+		fmt.Printf("# tags: %s\n", formatTags(h.Tags))
+	}
+	fmt.Printf("# source: %s\n", h.Source)
+	if h.Comment != "" {
+		fmt.Printf("Host %s # %s\n", h.Host, h.Comment)
+	} else {
+		fmt.Printf("Host %s\n", h.Host)
+	}
+	for _, o := range h.sshOptions() {
+		fmt.Printf("    %s %s\n", o.name, o.value)
+	}
+	// jump's own defaults apply to the options the config leaves unset.
+	defaults := newHost(h.Host)
+	set := make(map[string]bool)
+	for _, o := range h.resolved {
+		set[o.key] = true
+	}
+	for _, o := range []configOption{
+		{key: "stricthostkeychecking", name: "StrictHostKeyChecking", value: defaults.StrictHostKeyChecking},
+		{key: "userknownhostsfile", name: "UserKnownHostsFile", value: defaults.UserKnownHostsFile},
+	} {
+		if !set[o.key] {
+			fmt.Printf("    %s %s\n", o.name, o.value)
+		}
+	}
+	return exitOK
+}
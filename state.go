@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const recentSize = 10
+
+// hostState is what jump remembers of a host alias between runs.
+type hostState struct {
+	ID       int        `json:"id,omitempty"`
+	Count    int        `json:"count,omitempty"`
+	Last     *time.Time `json:"last,omitempty"`
+	Favorite bool       `json:"favorite,omitempty"`
+}
+
+// stateFile is the history of connections, the favorite hosts and the IDs
+// given to hosts, kept in $XDG_STATE_HOME/jump/history.json.
+type stateFile struct {
+	Hosts map[string]*hostState `json:"hosts"`
+}
+
+var state = &stateFile{Hosts: make(map[string]*hostState)}
+
+func statePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "state")
+	}
+	return filepath.Join(dir, "jump", "history.json")
+}
+
+// loadState reads the state file. A missing file is an empty history.
+func loadState() error {
+	data, err := ioutil.ReadFile(statePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s := &stateFile{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return fmt.Errorf("%s: %v", statePath(), err)
+	}
+	if s.Hosts == nil {
+		s.Hosts = make(map[string]*hostState)
+	}
+	state = s
+	return nil
+}
+
+func (s *stateFile) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(statePath(), append(data, '\n'))
+}
+
+func (s *stateFile) host(alias string) *hostState {
+	hs, ok := s.Hosts[alias]
+	if !ok {
+		hs = &hostState{}
+		s.Hosts[alias] = hs
+	}
+	return hs
+}
+
+func (s *stateFile) connected(alias string) {
+	hs := s.host(alias)
+	now := time.Now()
+	hs.Count++
+	hs.Last = &now
+}
+
+func (s *stateFile) toggleFavorite(alias string) {
+	hs := s.host(alias)
+	hs.Favorite = !hs.Favorite
+}
+
+func (s *stateFile) isFavorite(alias string) bool {
+	hs, ok := s.Hosts[alias]
+	return ok && hs.Favorite
+}
+
+// frecency weighs the connections to alias by how recent the last one is,
+// as browsers do for their address bar.
+func (s *stateFile) frecency(alias string) float64 {
+	hs, ok := s.Hosts[alias]
+	if !ok || hs.Count == 0 || hs.Last == nil {
+		return 0
+	}
+	age := time.Since(*hs.Last)
+	switch {
+	case age < time.Hour:
+		return float64(hs.Count) * 4
+	case age < 24*time.Hour:
+		return float64(hs.Count) * 2
+	case age < 7*24*time.Hour:
+		return float64(hs.Count)
+	case age < 30*24*time.Hour:
+		return float64(hs.Count) / 2
+	}
+	return float64(hs.Count) / 4
+}
+
+// favoriteHosts returns the favorites of list, most used first.
+func favoriteHosts(list []*Host) []*Host {
+	favorites := make([]*Host, 0)
+	for _, h := range list {
+		if state.isFavorite(h.Host) {
+			favorites = append(favorites, h)
+		}
+	}
+	sort.SliceStable(favorites, func(i, j int) bool {
+		return state.frecency(favorites[i].Host) > state.frecency(favorites[j].Host)
+	})
+	return favorites
+}
+
+// recentHosts returns the hosts of list connected to last, newest first.
+func recentHosts(list []*Host) []*Host {
+	recent := make([]*Host, 0)
+	for _, h := range list {
+		if hs, ok := state.Hosts[h.Host]; ok && hs.Last != nil {
+			recent = append(recent, h)
+		}
+	}
+	sort.SliceStable(recent, func(i, j int) bool {
+		return state.Hosts[recent[i].Host].Last.After(*state.Hosts[recent[j].Host].Last)
+	})
+	if len(recent) > recentSize {
+		recent = recent[:recentSize]
+	}
+	return recent
+}
+
+// Favorite tells the host list templates whether h is pinned.
+func (h *Host) Favorite() bool {
+	return state.isFavorite(h.Host)
+}
+
+// assignIDs gives every host of list a stable ID: the id tag of its comment
+// or inventory entry, else the ID the state file has for its alias, else the
+// lowest number no alias ever had, which is then recorded.
+func assignIDs(list []*Host) error {
+	used := make(map[int]string)
+	pending := make([]*Host, 0)
+	for _, h := range list {
+		tag, ok := h.Tags["id"]
+		if !ok {
+			pending = append(pending, h)
+			continue
+		}
+		delete(h.Tags, "id")
+		id, err := strconv.Atoi(tag)
+		switch {
+		case err != nil || id <= 0:
+			warnings = append(warnings, fmt.Sprintf("%s: id %q is not a positive number", h.Host, tag))
+		case used[id] != "":
+			warnings = append(warnings, fmt.Sprintf("%s: id %d is already used by %s", h.Host, id, used[id]))
+		default:
+			h.Index = id
+			used[id] = h.Host
+			continue
+		}
+		pending = append(pending, h)
+	}
+
+	recorded := make(map[int]string)
+	for alias, hs := range state.Hosts {
+		if hs.ID > 0 {
+			recorded[hs.ID] = alias
+		}
+	}
+	fresh := make([]*Host, 0)
+	for _, h := range pending {
+		hs, ok := state.Hosts[h.Host]
+		if ok && hs.ID > 0 && used[hs.ID] == "" {
+			h.Index = hs.ID
+			used[hs.ID] = h.Host
+			continue
+		}
+		fresh = append(fresh, h)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	next := 1
+	for _, h := range fresh {
+		for used[next] != "" || recorded[next] != "" {
+			next++
+		}
+		h.Index = next
+		used[next] = h.Host
+		state.host(h.Host).ID = next
+	}
+	return state.save()
+}
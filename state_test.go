@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func testHosts(tags map[string]map[string]string, aliases ...string) []*Host {
+	list := make([]*Host, 0, len(aliases))
+	for _, alias := range aliases {
+		h := newHost(alias)
+		h.Tags = make(map[string]string)
+		for k, v := range tags[alias] {
+			h.Tags[k] = v
+		}
+		list = append(list, h)
+	}
+	return list
+}
+
+func TestAssignIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		recorded map[string]int
+		tags     map[string]map[string]string
+		aliases  []string
+		want     map[string]int
+		saved    bool
+	}{
+		{
+			name:    "new hosts get the lowest free IDs",
+			aliases: []string{"a", "b"},
+			want:    map[string]int{"a": 1, "b": 2},
+			saved:   true,
+		},
+		{
+			name:     "recorded IDs are kept without saving",
+			recorded: map[string]int{"a": 4, "b": 2},
+			aliases:  []string{"a", "b"},
+			want:     map[string]int{"a": 4, "b": 2},
+		},
+		{
+			name:    "id tags are used without saving",
+			tags:    map[string]map[string]string{"a": {"id": "9"}},
+			aliases: []string{"a"},
+			want:    map[string]int{"a": 9},
+		},
+		{
+			name:     "IDs of removed aliases are not given again",
+			recorded: map[string]int{"gone": 1},
+			aliases:  []string{"a"},
+			want:     map[string]int{"a": 2},
+			saved:    true,
+		},
+		{
+			name:     "a recorded ID taken by an id tag is replaced",
+			recorded: map[string]int{"a": 1},
+			tags:     map[string]map[string]string{"b": {"id": "1"}},
+			aliases:  []string{"a", "b"},
+			want:     map[string]int{"a": 2, "b": 1},
+			saved:    true,
+		},
+		{
+			name:    "a bad id tag falls back to a new ID",
+			tags:    map[string]map[string]string{"a": {"id": "x"}},
+			aliases: []string{"a"},
+			want:    map[string]int{"a": 1},
+			saved:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("XDG_STATE_HOME", t.TempDir())
+			state = &stateFile{Hosts: make(map[string]*hostState)}
+			for alias, id := range tt.recorded {
+				state.host(alias).ID = id
+			}
+			list := testHosts(tt.tags, tt.aliases...)
+			if err := assignIDs(list); err != nil {
+				t.Fatal(err)
+			}
+			for _, h := range list {
+				if h.Index != tt.want[h.Host] {
+					t.Errorf("%s: ID %d, want %d", h.Host, h.Index, tt.want[h.Host])
+				}
+				if _, ok := h.Tags["id"]; ok {
+					t.Errorf("%s: id tag left in Tags", h.Host)
+				}
+			}
+			if _, err := os.Stat(statePath()); (err == nil) != tt.saved {
+				t.Errorf("state saved: %v, want %v", err == nil, tt.saved)
+			}
+		})
+	}
+}
+
+func TestAssignIDsStable(t *testing.T) {
+	os.Setenv("XDG_STATE_HOME", t.TempDir())
+	state = &stateFile{Hosts: make(map[string]*hostState)}
+	if err := assignIDs(testHosts(nil, "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(statePath()); err != nil {
+		t.Fatal(err)
+	}
+
+	list := testHosts(nil, "b", "a")
+	if err := assignIDs(list); err != nil {
+		t.Fatal(err)
+	}
+	if list[0].Index != 2 || list[1].Index != 1 {
+		t.Errorf("IDs changed on reload: b=%d a=%d", list[0].Index, list[1].Index)
+	}
+	if _, err := os.Stat(statePath()); err == nil {
+		t.Error("state saved though no ID was assigned")
+	}
+}
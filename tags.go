@@ -0,0 +1,89 @@
+package main
+
+import (
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+)
+
+// chipStyles colors tag chips, picked by the tag key so a key keeps its color
+// on every row.
+var chipStyles = []func(interface{}) string{
+	promptui.Styler(promptui.BGBlue, promptui.FGWhite),
+	promptui.Styler(promptui.BGMagenta, promptui.FGWhite),
+	promptui.Styler(promptui.BGCyan, promptui.FGBlack),
+	promptui.Styler(promptui.BGGreen, promptui.FGBlack),
+	promptui.Styler(promptui.BGYellow, promptui.FGBlack),
+	promptui.Styler(promptui.BGRed, promptui.FGWhite),
+}
+
+// tagKey is what a tag name must look like for a word of a comment to be
+// taken as a tag, so that text such as "1+1=2" or "x==y" stays text.
+var tagKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// parseComment splits a host comment into its tags, written as key=value or
+// #key, and the rest of the text.
+func parseComment(comment string) (map[string]string, string) {
+	tags := make(map[string]string)
+	rest := make([]string, 0)
+	for _, word := range strings.Fields(comment) {
+		if key, value, ok := parseTag(word); ok {
+			tags[key] = value
+			continue
+		}
+		rest = append(rest, word)
+	}
+	return tags, strings.Join(rest, " ")
+}
+
+// parseTag reads a word written as #key or key=value, where key starts with
+// a letter or underscore followed by letters, digits, _, . or -, and value is
+// neither empty nor starts with another =.
+func parseTag(word string) (string, string, bool) {
+	if strings.HasPrefix(word, "#") && tagKey.MatchString(word[1:]) {
+		return word[1:], "", true
+	}
+	if eq := strings.IndexByte(word, '='); eq > 0 && eq < len(word)-1 && word[eq+1] != '=' && tagKey.MatchString(word[:eq]) {
+		return word[:eq], word[eq+1:], true
+	}
+	return "", "", false
+}
+
+// mergeTags returns the tags of base overlaid with those of top.
+func mergeTags(base, top map[string]string) map[string]string {
+	if len(base) == 0 && len(top) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(base)+len(top))
+	for k, v := range base {
+		tags[k] = v
+	}
+	for k, v := range top {
+		tags[k] = v
+	}
+	return tags
+}
+
+// Chips renders the tags of h as colored labels for the host list.
+func (h *Host) Chips() string {
+	keys := make([]string, 0, len(h.Tags))
+	for k := range h.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	chips := make([]string, 0, len(keys))
+	for _, k := range keys {
+		label := k
+		if h.Tags[k] != "" {
+			label += "=" + h.Tags[k]
+		}
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(k))
+		chips = append(chips, chipStyles[hash.Sum32()%uint32(len(chipStyles))](" "+label+" "))
+	}
+	return strings.Join(chips, " ")
+}
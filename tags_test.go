@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		word  string
+		key   string
+		value string
+		ok    bool
+	}{
+		{"env=prod", "env", "prod", true},
+		{"#db", "db", "", true},
+		{"team.name=ops-1", "team.name", "ops-1", true},
+		{"_x=a=b", "_x", "a=b", true},
+		{"url=http://a/?b=c", "url", "http://a/?b=c", true},
+		{"env=", "", "", false},
+		{"=prod", "", "", false},
+		{"1+1=2", "", "", false},
+		{"x==y", "", "", false},
+		{"2fa=on", "", "", false},
+		{"#", "", "", false},
+		{"#1", "", "", false},
+		{"##db", "", "", false},
+		{"prod", "", "", false},
+	}
+	for _, tt := range tests {
+		key, value, ok := parseTag(tt.word)
+		if key != tt.key || value != tt.value || ok != tt.ok {
+			t.Errorf("parseTag(%q) = %q, %q, %v, want %q, %q, %v", tt.word, key, value, ok, tt.key, tt.value, tt.ok)
+		}
+	}
+}
+
+func TestParseComment(t *testing.T) {
+	tests := []struct {
+		comment string
+		tags    map[string]string
+		rest    string
+	}{
+		{"", map[string]string{}, ""},
+		{"nginx front", map[string]string{}, "nginx front"},
+		{"nginx env=prod #web", map[string]string{"env": "prod", "web": ""}, "nginx"},
+		{"rack #3, a+b=c", map[string]string{}, "rack #3, a+b=c"},
+		{"env=dev  main   box env=prod", map[string]string{"env": "prod"}, "main box"},
+	}
+	for _, tt := range tests {
+		tags, rest := parseComment(tt.comment)
+		if !reflect.DeepEqual(tags, tt.tags) || rest != tt.rest {
+			t.Errorf("parseComment(%q) = %v, %q, want %v, %q", tt.comment, tags, rest, tt.tags, tt.rest)
+		}
+	}
+}